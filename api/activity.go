@@ -0,0 +1,110 @@
+package main
+
+const (
+	ActivityIntervalHour = "hour"
+	ActivityIntervalDay  = "day"
+)
+
+// activityIntervals are the durations, in seconds, of the activity intervals.
+var activityIntervals = map[string]uint64{
+	ActivityIntervalHour: 3600,
+	ActivityIntervalDay:  86400,
+}
+
+// ActivityBucket aggregates the main chain blocks whose timestamp falls in the
+// hour or the day starting at Start.
+type ActivityBucket struct {
+	Interval     string `json:"interval"`
+	Start        uint64 `json:"start"`
+	Blocks       int    `json:"blocks"`
+	Txs          int    `json:"txs"`
+	OutputVolume uint64 `json:"output_volume"`
+	TotalSize    int    `json:"total_size"`
+	// TotalInterval is the sum of the time elapsed since their parent for
+	// the Intervals blocks having one.
+	TotalInterval   int64 `json:"total_interval"`
+	Intervals       int   `json:"intervals"`
+	ActiveAddresses int   `json:"active_addresses"`
+}
+
+// BlockActivity is the contribution of a block to the bucket its timestamp
+// falls in.
+type BlockActivity struct {
+	Timestamp    uint64
+	Txs          int
+	OutputVolume uint64
+	Size         int
+	Interval     int64
+	HasInterval  bool
+	// Addresses are the addresses credited or debited by the block.
+	Addresses map[string]struct{}
+}
+
+// NewBlockActivity computes the activity of a block. parent is nil for the
+// genesis block. findOutput returns the output spent by an input, or nil if it
+// is unknown.
+func NewBlockActivity(block *Block, parent *Block, txs []*Tx, findOutput func(*Outpoint) (*TxOutput, error)) (*BlockActivity, error) {
+	activity := &BlockActivity{
+		Timestamp: block.Timestamp,
+		Txs:       len(txs),
+		Addresses: make(map[string]struct{}),
+	}
+
+	if block.Stats != nil {
+		activity.Size = block.Stats.Size
+	} else {
+		activity.Size = BlockSize(block, txs)
+	}
+
+	if parent != nil {
+		activity.Interval = int64(block.Timestamp) - int64(parent.Timestamp)
+		activity.HasInterval = true
+	}
+
+	for _, tx := range txs {
+		for _, input := range tx.Inputs {
+			spentOutput, err := findOutput(input.PreviousOutput)
+			if err != nil {
+				return nil, err
+			}
+
+			if spentOutput != nil {
+				activity.Addresses[ScriptToAddress(spentOutput.Script)] = struct{}{}
+			}
+		}
+
+		for _, output := range tx.Outputs {
+			activity.OutputVolume += output.Value
+			activity.Addresses[ScriptToAddress(output.Script)] = struct{}{}
+		}
+	}
+
+	return activity, nil
+}
+
+// activityBucketStart returns the start of the bucket of the interval the
+// timestamp falls in.
+func activityBucketStart(interval string, timestamp uint64) uint64 {
+	return timestamp - timestamp%activityIntervals[interval]
+}
+
+// Apply adds the activity of a connected block to the bucket, or removes the
+// activity of a disconnected one if sign is negative. The active addresses
+// are counted by the storage, which knows which addresses are new to the
+// bucket.
+func (bucket *ActivityBucket) Apply(activity *BlockActivity, sign int) {
+	bucket.Blocks += sign
+	bucket.Txs += sign * activity.Txs
+	bucket.TotalSize += sign * activity.Size
+
+	if sign > 0 {
+		bucket.OutputVolume += activity.OutputVolume
+	} else {
+		bucket.OutputVolume -= activity.OutputVolume
+	}
+
+	if activity.HasInterval {
+		bucket.TotalInterval += int64(sign) * activity.Interval
+		bucket.Intervals += sign
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestActivityBucketStart(t *testing.T) {
+	tests := []struct {
+		interval  string
+		timestamp uint64
+		start     uint64
+	}{
+		{ActivityIntervalHour, 0, 0},
+		{ActivityIntervalHour, 3599, 0},
+		{ActivityIntervalHour, 3600, 3600},
+		{ActivityIntervalHour, 7250, 7200},
+		{ActivityIntervalDay, 86399, 0},
+		{ActivityIntervalDay, 90000, 86400},
+	}
+
+	for _, test := range tests {
+		if start := activityBucketStart(test.interval, test.timestamp); start != test.start {
+			t.Errorf("activityBucketStart(%q, %d) = %d, want %d", test.interval, test.timestamp, start, test.start)
+		}
+	}
+}
+
+func TestActivityBucketApply(t *testing.T) {
+	genesis := &BlockActivity{Txs: 1, OutputVolume: 50, Size: 100}
+	child := &BlockActivity{Txs: 3, OutputVolume: 120, Size: 400, Interval: 150, HasInterval: true}
+
+	tests := []struct {
+		name       string
+		activities []*BlockActivity
+		signs      []int
+		bucket     ActivityBucket
+	}{
+		{
+			"connect the genesis block",
+			[]*BlockActivity{genesis},
+			[]int{1},
+			ActivityBucket{Blocks: 1, Txs: 1, OutputVolume: 50, TotalSize: 100},
+		},
+		{
+			"connect a block",
+			[]*BlockActivity{genesis, child},
+			[]int{1, 1},
+			ActivityBucket{Blocks: 2, Txs: 4, OutputVolume: 170, TotalSize: 500, TotalInterval: 150, Intervals: 1},
+		},
+		{
+			"disconnect a block",
+			[]*BlockActivity{genesis, child, child},
+			[]int{1, 1, -1},
+			ActivityBucket{Blocks: 1, Txs: 1, OutputVolume: 50, TotalSize: 100},
+		},
+		{
+			"disconnect every block",
+			[]*BlockActivity{genesis, child, child, genesis},
+			[]int{1, 1, -1, -1},
+			ActivityBucket{},
+		},
+		{
+			"reconnect a block",
+			[]*BlockActivity{genesis, child, child, child},
+			[]int{1, 1, -1, 1},
+			ActivityBucket{Blocks: 2, Txs: 4, OutputVolume: 170, TotalSize: 500, TotalInterval: 150, Intervals: 1},
+		},
+	}
+
+	for _, test := range tests {
+		var bucket ActivityBucket
+
+		for i, activity := range test.activities {
+			bucket.Apply(activity, test.signs[i])
+		}
+
+		if bucket != test.bucket {
+			t.Errorf("%s: bucket = %+v, want %+v", test.name, bucket, test.bucket)
+		}
+	}
+}
+
+func TestNewBlockActivity(t *testing.T) {
+	spentOutput := &TxOutput{Value: 30, Script: "00"}
+
+	parent := &Block{Timestamp: 1000}
+	block := &Block{Timestamp: 1150, Stats: &BlockStats{Size: 250}}
+	txs := []*Tx{
+		{
+			Coinbase: true,
+			Inputs:   []*TxInput{{PreviousOutput: &Outpoint{}}},
+			Outputs:  []*TxOutput{{Value: 50, Script: "50"}},
+		},
+		{
+			Inputs:  []*TxInput{{PreviousOutput: &Outpoint{Hash: "aa"}}},
+			Outputs: []*TxOutput{{Value: 20, Script: "50"}, {Value: 10, Script: "51"}},
+		},
+	}
+
+	activity, err := NewBlockActivity(block, parent, txs, func(outpoint *Outpoint) (*TxOutput, error) {
+		if outpoint.Hash == "aa" {
+			return spentOutput, nil
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if activity.Timestamp != 1150 || activity.Txs != 2 || activity.OutputVolume != 80 || activity.Size != 250 {
+		t.Errorf("activity = %+v", activity)
+	}
+
+	if !activity.HasInterval || activity.Interval != 150 {
+		t.Errorf("interval = %d, %v, want 150, true", activity.Interval, activity.HasInterval)
+	}
+
+	for _, scriptHex := range []string{"00", "50", "51"} {
+		if _, ok := activity.Addresses[ScriptToAddress(scriptHex)]; !ok {
+			t.Errorf("address of %s not found", scriptHex)
+		}
+	}
+
+	if len(activity.Addresses) != 3 {
+		t.Errorf("len(addresses) = %d, want 3", len(activity.Addresses))
+	}
+
+	genesis, err := NewBlockActivity(parent, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if genesis.HasInterval {
+		t.Errorf("genesis interval = %d, want none", genesis.Interval)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/EnsicoinDevs/ensicoin-explorer/api/script"
+)
+
+// ScriptToAddress derives the address an output script pays to. Standard
+// pay-to-pubkey-hash scripts map to their hex-encoded pubkey hash, any other
+// script maps to the hex-encoded sha256 of the script itself.
+func ScriptToAddress(scriptHex string) string {
+	scriptBytes, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		return ""
+	}
+
+	if pubKeyHash, ok := script.ExtractPubKeyHash(scriptBytes); ok {
+		return hex.EncodeToString(pubKeyHash)
+	}
+
+	hash := sha256.Sum256(scriptBytes)
+
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,599 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/EnsicoinDevs/eccd/network"
+	pb "github.com/EnsicoinDevs/ensicoin-explorer/api/rpc"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxBlocksLimit         = 100
+	maxAddressEntriesLimit = 100
+	maxMempoolTxsLimit     = 100
+	// maxPaginationOffset bounds page * limit, well beyond the length of any
+	// list served, so that the offset can not overflow.
+	maxPaginationOffset = 1 << 30
+)
+
+type Api struct {
+	storage      Store
+	synchronizer *Synchronizer
+	poolTags     *PoolTags
+}
+
+// NewApi creates the API. poolTags may be nil, in which case no miner is
+// identified.
+func NewApi(storage Store, synchronizer *Synchronizer, poolTags *PoolTags) *Api {
+	return &Api{
+		storage:      storage,
+		synchronizer: synchronizer,
+		poolTags:     poolTags,
+	}
+}
+
+type ApiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func abortWithError(c *gin.Context, status int, code string, message string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"error": &ApiError{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
+func abortWithInternalError(c *gin.Context, err error) {
+	_ = c.Error(err)
+
+	abortWithError(c, http.StatusInternalServerError, "internal_error", err.Error())
+}
+
+// abortWithNodeError reports an error returned by the node, using the name of
+// the node Error enum as code when the node rejected the request.
+func abortWithNodeError(c *gin.Context, err error) {
+	if err == ErrNodeUnavailable {
+		abortWithError(c, http.StatusServiceUnavailable, "node_unavailable", err.Error())
+		return
+	}
+
+	st, _ := status.FromError(err)
+
+	switch st.Code() {
+	case codes.InvalidArgument:
+		abortWithError(c, http.StatusBadRequest, strings.ToLower(pb.Error_INVALID_DATA.String()), st.Message())
+	case codes.Unavailable, codes.Canceled, codes.DeadlineExceeded:
+		abortWithError(c, http.StatusServiceUnavailable, "node_unavailable", st.Message())
+	default:
+		_ = c.Error(err)
+
+		abortWithError(c, http.StatusBadGateway, "node_error", st.Message())
+	}
+}
+
+// parsePagination reads the page and limit query parameters, aborting the
+// request if they are invalid.
+func parsePagination(c *gin.Context, maxLimit int) (int, int, bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "0"))
+	if err != nil || page < 0 {
+		abortWithError(c, http.StatusBadRequest, "invalid_page", "page must be a non-negative integer")
+		return 0, 0, false
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > maxLimit {
+		abortWithError(c, http.StatusBadRequest, "invalid_limit", "limit must be an integer between 1 and "+strconv.Itoa(maxLimit))
+		return 0, 0, false
+	}
+
+	if page > maxPaginationOffset/limit {
+		abortWithError(c, http.StatusBadRequest, "invalid_page", "page * limit must not exceed "+strconv.Itoa(maxPaginationOffset))
+		return 0, 0, false
+	}
+
+	return page, limit, true
+}
+
+type BlockSummary struct {
+	Hash      string  `json:"hash"`
+	Height    uint32  `json:"height"`
+	Timestamp uint64  `json:"timestamp"`
+	TxCount   int     `json:"tx_count"`
+	Size      int     `json:"size"`
+	TotalFees uint64  `json:"total_fees"`
+	Reward    uint64  `json:"reward"`
+	Payout    *Payout `json:"payout,omitempty"`
+	Miner     string  `json:"miner,omitempty"`
+}
+
+// newBlockSummary summarizes a connected block from its stored stats.
+func newBlockSummary(block *Block) *BlockSummary {
+	summary := &BlockSummary{
+		Hash:      block.Hash,
+		Height:    block.Height,
+		Timestamp: block.Timestamp,
+		Payout:    block.Payout,
+		Miner:     block.Miner,
+	}
+
+	if block.Stats != nil {
+		summary.TxCount = block.Stats.TxCount
+		summary.Size = block.Stats.Size
+		summary.TotalFees = block.Stats.TotalFees
+		summary.Reward = block.Stats.Reward
+	}
+
+	return summary
+}
+
+func (a *Api) findBestBlock() (*Block, error) {
+	bestBlockHash, err := a.storage.FindBestBlockHash()
+	if err != nil {
+		return nil, err
+	}
+
+	return a.storage.FindBlockByHash(bestBlockHash)
+}
+
+// confirmations returns the number of blocks on top of block, itself included,
+// or 0 if block is not part of the main chain.
+func (a *Api) confirmations(block *Block, bestBlock *Block) (uint32, error) {
+	if block.Height > bestBlock.Height {
+		return 0, nil
+	}
+
+	inMainChain, err := a.storage.IsInMainChain(block.Hash)
+	if err != nil || !inMainChain {
+		return 0, err
+	}
+
+	return bestBlock.Height - block.Height + 1, nil
+}
+
+// handleBlocks serves a page of block summaries, the most recent first, along
+// with the best height under the bestHeight name the frontend reads.
+func (a *Api) handleBlocks(c *gin.Context) {
+	page, limit, ok := parsePagination(c, maxBlocksLimit)
+	if !ok {
+		return
+	}
+
+	blocks := []*BlockSummary{}
+
+	bestBlock, err := a.findBestBlock()
+	if err == ErrBestBlockHashNotFound {
+		c.JSON(http.StatusOK, gin.H{
+			"blocks": blocks,
+			"stats": gin.H{
+				"bestHeight": 0,
+			},
+		})
+		return
+	}
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	if start := int64(bestBlock.Height) - int64(page)*int64(limit); page <= int(bestBlock.Height) && start >= 0 {
+		end := start - int64(limit) + 1
+		if end < 0 {
+			end = 0
+		}
+
+		if err := a.storage.IterateBlocks(uint32(end), uint32(start), true, func(block *Block) error {
+			a.identifyMiner(block)
+			blocks = append(blocks, newBlockSummary(block))
+
+			return nil
+		}); err != nil {
+			abortWithInternalError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"blocks": blocks,
+		"stats": gin.H{
+			"bestHeight": bestBlock.Height,
+		},
+	})
+}
+
+type BlockDetail struct {
+	Header        *Block `json:"header"`
+	Txs           []*Tx  `json:"txs"`
+	NextBlock     string `json:"next_block,omitempty"`
+	Confirmations uint32 `json:"confirmations"`
+	Orphaned      bool   `json:"orphaned"`
+}
+
+func (a *Api) findBlock(id string) (*Block, error) {
+	if len(id) == 64 {
+		return a.storage.FindBlockByHash(id)
+	}
+
+	height, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, ErrBlockNotFound
+	}
+
+	return a.storage.FindBlockByHeight(uint32(height))
+}
+
+type NextBlock struct {
+	*BlockTemplate
+	Difficulty       float64   `json:"difficulty"`
+	TxCount          int       `json:"tx_count"`
+	TotalOutputValue uint64    `json:"total_output_value"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// handleNextBlock previews the next block from the latest block template of
+// the node.
+func (a *Api) handleNextBlock(c *gin.Context) {
+	template, txs, updatedAt := a.synchronizer.Mempool().Template()
+	if template == nil {
+		abortWithError(c, http.StatusServiceUnavailable, "block_template_unavailable", "no block template was received from the node")
+		return
+	}
+
+	nextBlock := &NextBlock{
+		BlockTemplate: template,
+		Difficulty:    TargetToDifficulty(template.Target),
+		TxCount:       len(txs),
+		UpdatedAt:     updatedAt,
+	}
+
+	for _, tx := range txs {
+		for _, output := range tx.Outputs {
+			nextBlock.TotalOutputValue += output.Value
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"block": nextBlock,
+	})
+}
+
+func (a *Api) handleBlock(c *gin.Context) {
+	// gin does not allow a static route next to the :id parameter.
+	if c.Param("id") == "next" {
+		a.handleNextBlock(c)
+		return
+	}
+
+	block, err := a.findBlock(c.Param("id"))
+	if err == ErrBlockNotFound {
+		abortWithError(c, http.StatusNotFound, "block_not_found", "no block matches "+c.Param("id"))
+		return
+	}
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	txs, err := a.storage.FindTxs(block.Hash)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	for _, tx := range txs {
+		DecodeScripts(tx)
+	}
+
+	a.identifyMiner(block)
+
+	detail := &BlockDetail{
+		Header: block,
+		Txs:    txs,
+	}
+
+	bestBlock, err := a.findBestBlock()
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	detail.Confirmations, err = a.confirmations(block, bestBlock)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	detail.Orphaned, err = a.storage.IsOrphanBlock(block.Hash)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	if detail.Confirmations > 0 {
+		nextBlock, err := a.storage.FindBlockByHeight(block.Height + 1)
+		if err != nil && err != ErrBlockNotFound {
+			abortWithInternalError(c, err)
+			return
+		}
+
+		if nextBlock != nil && nextBlock.PrevBlock == block.Hash {
+			detail.NextBlock = nextBlock.Hash
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"block": detail,
+	})
+}
+
+type TxDetail struct {
+	Tx            *ResolvedTx `json:"tx"`
+	BlockHash     string      `json:"block_hash"`
+	BlockHeight   uint32      `json:"block_height"`
+	Timestamp     uint64      `json:"timestamp"`
+	Index         int         `json:"index"`
+	Confirmations uint32      `json:"confirmations"`
+}
+
+// resolveTx attaches to every input the output it spends, and to every output
+// the input spending it, if any. The scripts are decoded along the way.
+func (a *Api) resolveTx(tx *Tx) (*ResolvedTx, error) {
+	DecodeScripts(tx)
+
+	resolvedTx := &ResolvedTx{
+		Tx:      tx,
+		Inputs:  []*ResolvedTxInput{},
+		Outputs: []*ResolvedTxOutput{},
+	}
+
+	for _, input := range tx.Inputs {
+		resolvedInput := &ResolvedTxInput{
+			TxInput: input,
+		}
+
+		previousTx, err := a.storage.FindTxByHash(input.PreviousOutput.Hash)
+		if err != nil && err != ErrTxNotFound {
+			return nil, err
+		}
+
+		if previousTx != nil && int(input.PreviousOutput.Index) < len(previousTx.Outputs) {
+			resolvedInput.SpentOutput = previousTx.Outputs[input.PreviousOutput.Index]
+			resolvedInput.SpentOutput.DecodeScript()
+		}
+
+		resolvedTx.Inputs = append(resolvedTx.Inputs, resolvedInput)
+	}
+
+	for index, output := range tx.Outputs {
+		spentBy, err := a.storage.FindSpentBy(&Outpoint{
+			Hash:  tx.Hash,
+			Index: uint32(index),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resolvedTx.Outputs = append(resolvedTx.Outputs, &ResolvedTxOutput{
+			TxOutput: output,
+			SpentBy:  spentBy,
+		})
+	}
+
+	return resolvedTx, nil
+}
+
+func (a *Api) handleTx(c *gin.Context) {
+	tx, err := a.storage.FindTxByHash(c.Param("hash"))
+	if err == ErrTxNotFound {
+		abortWithError(c, http.StatusNotFound, "tx_not_found", "no tx matches "+c.Param("hash"))
+		return
+	}
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	location, err := a.storage.FindTxLocation(tx.Hash)
+	if err == ErrTxNotFound {
+		abortWithError(c, http.StatusNotFound, "tx_not_found", "tx "+tx.Hash+" is not in the main chain")
+		return
+	}
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	block, err := a.storage.FindBlockByHash(location.BlockHash)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	bestBlock, err := a.findBestBlock()
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	confirmations, err := a.confirmations(block, bestBlock)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	resolvedTx, err := a.resolveTx(tx)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tx": &TxDetail{
+			Tx:            resolvedTx,
+			BlockHash:     block.Hash,
+			BlockHeight:   block.Height,
+			Timestamp:     block.Timestamp,
+			Index:         location.Index,
+			Confirmations: confirmations,
+		},
+	})
+}
+
+type BroadcastTxRequest struct {
+	RawTx string `json:"raw_tx" binding:"required"`
+}
+
+func (a *Api) handleBroadcastTx(c *gin.Context) {
+	var request BroadcastTxRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_request", "the body must be a JSON object with a raw_tx field")
+		return
+	}
+
+	rawTx, err := hex.DecodeString(request.RawTx)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_raw_tx", "raw_tx must be hex encoded")
+		return
+	}
+
+	// The tx is decoded to return its hash, the node replying nothing but
+	// errors.
+	txMsg := network.NewTxMessage()
+	if err := txMsg.Decode(bytes.NewReader(rawTx)); err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_raw_tx", "raw_tx is not a serialized tx")
+		return
+	}
+
+	if err := a.synchronizer.PublishRawTx(rawTx); err != nil {
+		abortWithNodeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hash": txMsg.Hash().String(),
+	})
+}
+
+func (a *Api) handleAddress(c *gin.Context) {
+	page, limit, ok := parsePagination(c, maxAddressEntriesLimit)
+	if !ok {
+		return
+	}
+
+	addr, err := a.storage.FindAddress(c.Param("addr"))
+	if err == ErrAddressNotFound {
+		abortWithError(c, http.StatusNotFound, "address_not_found", "no address matches "+c.Param("addr"))
+		return
+	}
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	entries, err := a.storage.FindAddressEntries(addr.Address, page, limit)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"address": addr,
+		"history": entries,
+	})
+}
+
+func (a *Api) handleMempool(c *gin.Context) {
+	page, limit, ok := parsePagination(c, maxMempoolTxsLimit)
+	if !ok {
+		return
+	}
+
+	txs, count := a.synchronizer.Mempool().Txs(page, limit)
+
+	c.JSON(http.StatusOK, gin.H{
+		"txs":   txs,
+		"count": count,
+	})
+}
+
+func (a *Api) handleMempoolTx(c *gin.Context) {
+	tx, ok := a.synchronizer.Mempool().FindTx(c.Param("hash"))
+	if !ok {
+		abortWithError(c, http.StatusNotFound, "tx_not_found", "no pending tx matches "+c.Param("hash"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tx": tx,
+	})
+}
+
+// ChainStatsDetail serves the best height under the bestHeight name the
+// frontend reads, as handleBlocks does.
+type ChainStatsDetail struct {
+	*ChainStats
+	BestHeight    uint32 `json:"bestHeight"`
+	BestBlockHash string `json:"best_block_hash,omitempty"`
+	// AverageBlockInterval is the mean time between two blocks of the main
+	// chain, in seconds.
+	AverageBlockInterval float64 `json:"average_block_interval"`
+}
+
+func (a *Api) handleStats(c *gin.Context) {
+	stats, err := a.storage.FindChainStats()
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	detail := &ChainStatsDetail{
+		ChainStats:           stats,
+		AverageBlockInterval: stats.AverageBlockInterval(),
+	}
+
+	bestBlock, err := a.findBestBlock()
+	if err != nil && err != ErrBestBlockHashNotFound {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	if bestBlock != nil {
+		detail.BestHeight = bestBlock.Height
+		detail.BestBlockHash = bestBlock.Hash
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stats": detail,
+	})
+}
+
+func (a *Api) handleStatus(c *gin.Context) {
+	status := gin.H{
+		"sync": a.synchronizer.Status(),
+	}
+
+	bestBlock, err := a.findBestBlock()
+	if err != nil && err != ErrBestBlockHashNotFound {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	if bestBlock != nil {
+		status["best_block"] = gin.H{
+			"hash":      bestBlock.Hash,
+			"height":    bestBlock.Height,
+			"timestamp": bestBlock.Timestamp,
+		}
+	}
+
+	c.JSON(http.StatusOK, status)
+}
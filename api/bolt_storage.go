@@ -0,0 +1,1212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var (
+	statsBucket          = []byte("stats")
+	blocksBucket         = []byte("blocks")
+	txsBucket            = []byte("txs")
+	blockToTxsBucket     = []byte("blockToTxs")
+	heightToBlockBucket  = []byte("heightToBlock")
+	txToBlockBucket      = []byte("txToBlock")
+	spentOutputsBucket   = []byte("spentOutputs")
+	addressesBucket      = []byte("addresses")
+	addressEntriesBucket = []byte("addressEntries")
+	orphanBlocksBucket   = []byte("orphanBlocks")
+	headersBucket        = []byte("headers")
+	headerTxsBucket      = []byte("headerTxs")
+	// activityBucket maps an interval and a bucket start to the bucket, and
+	// activityAddressesBucket maps them along with an address to the number
+	// of blocks of the bucket the address is active in.
+	activityBucket          = []byte("activity")
+	activityAddressesBucket = []byte("activityAddresses")
+)
+
+var (
+	bestBlockHashKey  = []byte("bestBlockHash")
+	syncCheckpointKey = []byte("syncCheckpoint")
+	chainStatsKey     = []byte("chainStats")
+)
+
+type BoltStorage struct {
+	path string
+	db   *bolt.DB
+}
+
+func NewBoltStorage(path string) *BoltStorage {
+	return &BoltStorage{
+		path: path,
+	}
+}
+
+func (s *BoltStorage) Open() (err error) {
+	if err = s.open(); err != nil {
+		return err
+	}
+
+	return s.Migrate(false, logMigrationProgress)
+}
+
+// open opens the database and creates its buckets without migrating it.
+func (s *BoltStorage) open() (err error) {
+	s.db, err = bolt.Open(s.path, 0600, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.bootstrap()
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStorage) bootstrap() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(statsBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(blocksBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(txsBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(blockToTxsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(heightToBlockBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(txToBlockBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(spentOutputsBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(addressesBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(addressEntriesBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(orphanBlocksBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(headersBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(headerTxsBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(activityBucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucketIfNotExists(activityAddressesBucket); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// heightKey encodes a height so that the keys of heightToBlockBucket are
+// sorted by height.
+func heightKey(height uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, height)
+
+	return key
+}
+
+func (s *BoltStorage) FindBestBlockHash() (string, error) {
+	var hash string
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		bestBlockHashBytes := tx.Bucket(statsBucket).Get(bestBlockHashKey)
+		if bestBlockHashBytes == nil {
+			return ErrBestBlockHashNotFound
+		}
+
+		hash = string(bestBlockHashBytes)
+
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	return hash, nil
+}
+
+func storeBlock(btx *bolt.Tx, block *Block) error {
+	blockBytes, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+
+	if err := btx.Bucket(blocksBucket).Put([]byte(block.Hash), blockBytes); err != nil {
+		return err
+	}
+
+	if err := btx.Bucket(orphanBlocksBucket).Delete([]byte(block.Hash)); err != nil {
+		return err
+	}
+
+	return btx.Bucket(heightToBlockBucket).Put(heightKey(block.Height), []byte(block.Hash))
+}
+
+func (s *BoltStorage) HasBlock(hash string) (bool, error) {
+	var exist bool
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		exist = tx.Bucket(blocksBucket).Get([]byte(hash)) != nil
+
+		return nil
+	}); err != nil {
+		return false, err
+	}
+
+	return exist, nil
+}
+
+func findBlock(btx *bolt.Tx, hash string) (*Block, error) {
+	blockBytes := btx.Bucket(blocksBucket).Get([]byte(hash))
+	if blockBytes == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	var block *Block
+
+	return block, json.Unmarshal(blockBytes, &block)
+}
+
+func (s *BoltStorage) FindBlockByHash(hash string) (block *Block, err error) {
+	err = s.db.View(func(btx *bolt.Tx) error {
+		block, err = findBlock(btx, hash)
+
+		return err
+	})
+
+	return
+}
+
+func (s *BoltStorage) FindBlockByHeight(height uint32) (block *Block, err error) {
+	var blockHash string
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		blockHashBytes := tx.Bucket(heightToBlockBucket).Get(heightKey(height))
+		if blockHashBytes == nil {
+			return ErrBlockNotFound
+		}
+
+		blockHash = string(blockHashBytes)
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return s.FindBlockByHash(blockHash)
+}
+
+// IterateBlocks calls fn on the main chain blocks from fromHeight to toHeight
+// included, in ascending order or in descending order if reverse is set. fn is
+// called within a read transaction and must not use the storage.
+func (s *BoltStorage) IterateBlocks(fromHeight uint32, toHeight uint32, reverse bool, fn func(*Block) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		blocks := tx.Bucket(blocksBucket)
+		c := tx.Bucket(heightToBlockBucket).Cursor()
+
+		var k, v []byte
+		if reverse {
+			k, v = c.Seek(heightKey(toHeight))
+			if k == nil {
+				k, v = c.Last()
+			} else if binary.BigEndian.Uint32(k) > toHeight {
+				k, v = c.Prev()
+			}
+		} else {
+			k, v = c.Seek(heightKey(fromHeight))
+		}
+
+		for k != nil {
+			height := binary.BigEndian.Uint32(k)
+			if height < fromHeight || height > toHeight {
+				break
+			}
+
+			blockBytes := blocks.Get(v)
+			if blockBytes == nil {
+				return ErrBlockNotFound
+			}
+
+			var block *Block
+			if err := json.Unmarshal(blockBytes, &block); err != nil {
+				return err
+			}
+
+			if err := fn(block); err != nil {
+				return err
+			}
+
+			if reverse {
+				k, v = c.Prev()
+			} else {
+				k, v = c.Next()
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BoltStorage) FindBlocksByHeights(heights []uint32) (map[uint32]*Block, error) {
+	blocks := make(map[uint32]*Block)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, height := range heights {
+			blockHash := tx.Bucket(heightToBlockBucket).Get(heightKey(height))
+			if blockHash == nil {
+				continue
+			}
+
+			block, err := findBlock(tx, string(blockHash))
+			if err != nil {
+				return err
+			}
+
+			blocks[height] = block
+		}
+
+		return nil
+	})
+
+	return blocks, err
+}
+
+func storeTxs(btx *bolt.Tx, blockHash string, txs []*Tx) error {
+	var txHashes []string
+
+	for index, tx := range txs {
+		txBytes, err := json.Marshal(tx)
+		if err != nil {
+			return err
+		}
+
+		if err := btx.Bucket(txsBucket).Put([]byte(tx.Hash), txBytes); err != nil {
+			return err
+		}
+
+		locationBytes, err := json.Marshal(&TxLocation{
+			BlockHash: blockHash,
+			Index:     index,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := btx.Bucket(txToBlockBucket).Put([]byte(tx.Hash), locationBytes); err != nil {
+			return err
+		}
+
+		txHashes = append(txHashes, tx.Hash)
+	}
+
+	txHashesBytes, err := json.Marshal(txHashes)
+	if err != nil {
+		return err
+	}
+
+	return btx.Bucket(blockToTxsBucket).Put([]byte(blockHash), txHashesBytes)
+}
+
+func findTx(btx *bolt.Tx, hash string) (*Tx, error) {
+	txBytes := btx.Bucket(txsBucket).Get([]byte(hash))
+	if txBytes == nil {
+		return nil, ErrTxNotFound
+	}
+
+	var tx *Tx
+	if err := json.Unmarshal(txBytes, &tx); err != nil {
+		return nil, err
+	}
+
+	// The txs stored before the coinbase flag existed lack it.
+	tx.Coinbase = isCoinbase(tx)
+
+	return tx, nil
+}
+
+func findBlockTxs(btx *bolt.Tx, blockHash string) ([]*Tx, error) {
+	txHashesBytes := btx.Bucket(blockToTxsBucket).Get([]byte(blockHash))
+	if txHashesBytes == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	var txHashes []string
+	if err := json.Unmarshal(txHashesBytes, &txHashes); err != nil {
+		return nil, err
+	}
+
+	var txs []*Tx
+
+	for _, hash := range txHashes {
+		tx, err := findTx(btx, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
+
+func (s *BoltStorage) FindTxs(blockHash string) (txs []*Tx, err error) {
+	err = s.db.View(func(btx *bolt.Tx) error {
+		txs, err = findBlockTxs(btx, blockHash)
+
+		return err
+	})
+
+	return
+}
+
+func (s *BoltStorage) FindTxByHash(hash string) (tx *Tx, err error) {
+	err = s.db.View(func(btx *bolt.Tx) error {
+		tx, err = findTx(btx, hash)
+
+		return err
+	})
+
+	return
+}
+
+func (s *BoltStorage) FindTxLocation(hash string) (location *TxLocation, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		locationBytes := tx.Bucket(txToBlockBucket).Get([]byte(hash))
+		if locationBytes == nil {
+			return ErrTxNotFound
+		}
+
+		return json.Unmarshal(locationBytes, &location)
+	})
+
+	return
+}
+
+func outpointKey(outpoint *Outpoint) []byte {
+	return []byte(fmt.Sprintf("%s:%d", outpoint.Hash, outpoint.Index))
+}
+
+func storeSpentOutputs(btx *bolt.Tx, txs []*Tx) error {
+	for _, tx := range txs {
+		for index, input := range tx.Inputs {
+			spentByBytes, err := json.Marshal(&SpentBy{
+				TxHash:     tx.Hash,
+				InputIndex: index,
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := btx.Bucket(spentOutputsBucket).Put(outpointKey(input.PreviousOutput), spentByBytes); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// FindSpentBy returns the input spending the given outpoint, or nil if the
+// outpoint is unspent.
+func (s *BoltStorage) FindSpentBy(outpoint *Outpoint) (spentBy *SpentBy, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		spentByBytes := tx.Bucket(spentOutputsBucket).Get(outpointKey(outpoint))
+		if spentByBytes == nil {
+			return nil
+		}
+
+		return json.Unmarshal(spentByBytes, &spentBy)
+	})
+
+	return
+}
+
+// addressEntryKey orders the entries of an address by their position in the
+// chain: block height, tx index in the block, then debits before credits.
+func addressEntryKey(address string, entry *AddressEntry, txIndex int) []byte {
+	key := make([]byte, len(address)+1+4+4+1+4)
+
+	copy(key, address)
+	key[len(address)] = ':'
+	binary.BigEndian.PutUint32(key[len(address)+1:], entry.Height)
+	binary.BigEndian.PutUint32(key[len(address)+5:], uint32(txIndex))
+	if entry.Type == AddressEntryCredit {
+		key[len(address)+9] = 1
+	}
+	binary.BigEndian.PutUint32(key[len(address)+10:], uint32(entry.Index))
+
+	return key
+}
+
+func findAddress(btx *bolt.Tx, address string) (*Address, error) {
+	addressBytes := btx.Bucket(addressesBucket).Get([]byte(address))
+	if addressBytes == nil {
+		return nil, ErrAddressNotFound
+	}
+
+	var addr *Address
+
+	return addr, json.Unmarshal(addressBytes, &addr)
+}
+
+// blockAddressEntries returns, for every tx of the block, the address entries
+// it produces grouped by address.
+func blockAddressEntries(btx *bolt.Tx, block *Block, txs []*Tx) ([]map[string][]*AddressEntry, error) {
+	var blockEntries []map[string][]*AddressEntry
+
+	for _, tx := range txs {
+		entries := make(map[string][]*AddressEntry)
+
+		for index, input := range tx.Inputs {
+			previousTxBytes := btx.Bucket(txsBucket).Get([]byte(input.PreviousOutput.Hash))
+			if previousTxBytes == nil {
+				continue
+			}
+
+			var previousTx *Tx
+			if err := json.Unmarshal(previousTxBytes, &previousTx); err != nil {
+				return nil, err
+			}
+
+			if int(input.PreviousOutput.Index) >= len(previousTx.Outputs) {
+				continue
+			}
+
+			spentOutput := previousTx.Outputs[input.PreviousOutput.Index]
+			address := ScriptToAddress(spentOutput.Script)
+
+			entries[address] = append(entries[address], &AddressEntry{
+				Type:  AddressEntryDebit,
+				Index: index,
+				Value: spentOutput.Value,
+			})
+		}
+
+		for index, output := range tx.Outputs {
+			address := ScriptToAddress(output.Script)
+
+			entries[address] = append(entries[address], &AddressEntry{
+				Type:  AddressEntryCredit,
+				Index: index,
+				Value: output.Value,
+			})
+		}
+
+		for _, addressEntries := range entries {
+			for _, entry := range addressEntries {
+				entry.TxHash = tx.Hash
+				entry.BlockHash = block.Hash
+				entry.Height = block.Height
+				entry.Timestamp = block.Timestamp
+			}
+		}
+
+		blockEntries = append(blockEntries, entries)
+	}
+
+	return blockEntries, nil
+}
+
+func storeAddressEntries(btx *bolt.Tx, block *Block, txs []*Tx) error {
+	blockEntries, err := blockAddressEntries(btx, block, txs)
+	if err != nil {
+		return err
+	}
+
+	for txIndex, entries := range blockEntries {
+		for address, addressEntries := range entries {
+			addr, err := findAddress(btx, address)
+			if err == ErrAddressNotFound {
+				addr = &Address{
+					Address: address,
+				}
+			} else if err != nil {
+				return err
+			}
+
+			addr.TxCount++
+
+			for _, entry := range addressEntries {
+				if entry.Type == AddressEntryCredit {
+					addr.TotalReceived += entry.Value
+				} else {
+					addr.TotalSent += entry.Value
+				}
+
+				entryBytes, err := json.Marshal(entry)
+				if err != nil {
+					return err
+				}
+
+				if err := btx.Bucket(addressEntriesBucket).Put(addressEntryKey(address, entry, txIndex), entryBytes); err != nil {
+					return err
+				}
+			}
+
+			addr.Balance = addr.TotalReceived - addr.TotalSent
+
+			if err := storeAddress(btx, addr); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func removeAddressEntries(btx *bolt.Tx, block *Block, txs []*Tx) error {
+	blockEntries, err := blockAddressEntries(btx, block, txs)
+	if err != nil {
+		return err
+	}
+
+	for txIndex, entries := range blockEntries {
+		for address, addressEntries := range entries {
+			addr, err := findAddress(btx, address)
+			if err == ErrAddressNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			addr.TxCount--
+
+			for _, entry := range addressEntries {
+				if entry.Type == AddressEntryCredit {
+					addr.TotalReceived -= entry.Value
+				} else {
+					addr.TotalSent -= entry.Value
+				}
+
+				if err := btx.Bucket(addressEntriesBucket).Delete(addressEntryKey(address, entry, txIndex)); err != nil {
+					return err
+				}
+			}
+
+			addr.Balance = addr.TotalReceived - addr.TotalSent
+
+			if addr.TxCount <= 0 {
+				if err := btx.Bucket(addressesBucket).Delete([]byte(address)); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			if err := storeAddress(btx, addr); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func storeAddress(btx *bolt.Tx, addr *Address) error {
+	addrBytes, err := json.Marshal(addr)
+	if err != nil {
+		return err
+	}
+
+	return btx.Bucket(addressesBucket).Put([]byte(addr.Address), addrBytes)
+}
+
+func (s *BoltStorage) FindAddress(address string) (addr *Address, err error) {
+	err = s.db.View(func(btx *bolt.Tx) error {
+		addr, err = findAddress(btx, address)
+
+		return err
+	})
+
+	return
+}
+
+// FindAddressEntries returns a page of the history of an address, most recent
+// entries first.
+func (s *BoltStorage) FindAddressEntries(address string, page int, limit int) ([]*AddressEntry, error) {
+	entries := []*AddressEntry{}
+
+	prefix := []byte(address + ":")
+	end := []byte(address + ";")
+
+	if err := s.db.View(func(btx *bolt.Tx) error {
+		c := btx.Bucket(addressEntriesBucket).Cursor()
+
+		k, v := c.Seek(end)
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+
+		for skip := page * limit; k != nil && bytes.HasPrefix(k, prefix) && len(entries) < limit; k, v = c.Prev() {
+			if skip > 0 {
+				skip--
+				continue
+			}
+
+			var entry *AddressEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+
+			entries = append(entries, entry)
+		}
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// findSpentOutput returns the output an outpoint refers to, or nil if it is
+// unknown.
+func findSpentOutput(btx *bolt.Tx, outpoint *Outpoint) (*TxOutput, error) {
+	tx, err := findTx(btx, outpoint.Hash)
+	if err == ErrTxNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if int(outpoint.Index) >= len(tx.Outputs) {
+		return nil, nil
+	}
+
+	return tx.Outputs[outpoint.Index], nil
+}
+
+func connectBlock(btx *bolt.Tx, block *Block, txs []*Tx) error {
+	parent, err := findBlock(btx, block.PrevBlock)
+	if err != nil && err != ErrBlockNotFound {
+		return err
+	}
+
+	if err := ComputeStats(block, parent, txs, func(outpoint *Outpoint) (*TxOutput, error) {
+		return findSpentOutput(btx, outpoint)
+	}); err != nil {
+		return err
+	}
+
+	block.Payout = FindPayout(txs)
+
+	if err := storeBlock(btx, block); err != nil {
+		return err
+	}
+
+	if err := storeTxs(btx, block.Hash, txs); err != nil {
+		return err
+	}
+
+	if err := storeSpentOutputs(btx, txs); err != nil {
+		return err
+	}
+
+	if err := storeAddressEntries(btx, block, txs); err != nil {
+		return err
+	}
+
+	if err := btx.Bucket(headersBucket).Delete([]byte(block.Hash)); err != nil {
+		return err
+	}
+
+	if err := btx.Bucket(headerTxsBucket).Delete([]byte(block.Hash)); err != nil {
+		return err
+	}
+
+	if err := updateChainStats(btx, func(stats *ChainStats) {
+		stats.ConnectTxs(txs)
+		stats.ConnectBlock(block, parent)
+	}); err != nil {
+		return err
+	}
+
+	if err := updateActivity(btx, block, parent, txs, 1); err != nil {
+		return err
+	}
+
+	return btx.Bucket(statsBucket).Put(bestBlockHashKey, []byte(block.Hash))
+}
+
+func findChainStats(btx *bolt.Tx) (*ChainStats, error) {
+	stats := &ChainStats{}
+
+	if statsBytes := btx.Bucket(statsBucket).Get(chainStatsKey); statsBytes != nil {
+		if err := json.Unmarshal(statsBytes, stats); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+func putChainStats(btx *bolt.Tx, stats *ChainStats) error {
+	statsBytes, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	return btx.Bucket(statsBucket).Put(chainStatsKey, statsBytes)
+}
+
+func updateChainStats(btx *bolt.Tx, update func(*ChainStats)) error {
+	stats, err := findChainStats(btx)
+	if err != nil {
+		return err
+	}
+
+	update(stats)
+
+	return putChainStats(btx, stats)
+}
+
+func activityKey(interval string, start uint64) []byte {
+	key := make([]byte, len(interval)+8)
+	copy(key, interval)
+	binary.BigEndian.PutUint64(key[len(interval):], start)
+
+	return key
+}
+
+// updateActivity adds the activity of a connected block to its buckets, or
+// removes the activity of a disconnected one if sign is negative.
+func updateActivity(btx *bolt.Tx, block *Block, parent *Block, txs []*Tx, sign int) error {
+	activity, err := NewBlockActivity(block, parent, txs, func(outpoint *Outpoint) (*TxOutput, error) {
+		return findSpentOutput(btx, outpoint)
+	})
+	if err != nil {
+		return err
+	}
+
+	for interval := range activityIntervals {
+		key := activityKey(interval, activityBucketStart(interval, activity.Timestamp))
+
+		bucket := &ActivityBucket{
+			Interval: interval,
+			Start:    activityBucketStart(interval, activity.Timestamp),
+		}
+
+		if bucketBytes := btx.Bucket(activityBucket).Get(key); bucketBytes != nil {
+			if err := json.Unmarshal(bucketBytes, bucket); err != nil {
+				return err
+			}
+		}
+
+		for address := range activity.Addresses {
+			addressKey := append(append([]byte(nil), key...), address...)
+
+			var count uint32
+			if countBytes := btx.Bucket(activityAddressesBucket).Get(addressKey); countBytes != nil {
+				count = binary.BigEndian.Uint32(countBytes)
+			}
+
+			if sign > 0 {
+				count++
+
+				if count == 1 {
+					bucket.ActiveAddresses++
+				}
+			} else {
+				// An address that was never counted is left as is.
+				if count == 0 {
+					continue
+				}
+
+				count--
+
+				if count == 0 {
+					bucket.ActiveAddresses--
+
+					if err := btx.Bucket(activityAddressesBucket).Delete(addressKey); err != nil {
+						return err
+					}
+
+					continue
+				}
+			}
+
+			countBytes := make([]byte, 4)
+			binary.BigEndian.PutUint32(countBytes, count)
+
+			if err := btx.Bucket(activityAddressesBucket).Put(addressKey, countBytes); err != nil {
+				return err
+			}
+		}
+
+		bucket.Apply(activity, sign)
+
+		if bucket.Blocks == 0 {
+			if err := btx.Bucket(activityBucket).Delete(key); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		bucketBytes, err := json.Marshal(bucket)
+		if err != nil {
+			return err
+		}
+
+		if err := btx.Bucket(activityBucket).Put(key, bucketBytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *BoltStorage) FindActivityBuckets(interval string, from uint64, to uint64) (buckets []*ActivityBucket, err error) {
+	buckets = []*ActivityBucket{}
+
+	err = s.db.View(func(btx *bolt.Tx) error {
+		prefix := []byte(interval)
+		end := activityKey(interval, to)
+
+		c := btx.Bucket(activityBucket).Cursor()
+
+		for k, v := c.Seek(activityKey(interval, from)); k != nil && bytes.HasPrefix(k, prefix) && bytes.Compare(k, end) <= 0; k, v = c.Next() {
+			var bucket ActivityBucket
+			if err := json.Unmarshal(v, &bucket); err != nil {
+				return err
+			}
+
+			buckets = append(buckets, &bucket)
+		}
+
+		return nil
+	})
+
+	return
+}
+
+func putCheckpoint(btx *bolt.Tx, checkpoint *Checkpoint) error {
+	checkpointBytes, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	return btx.Bucket(statsBucket).Put(syncCheckpointKey, checkpointBytes)
+}
+
+func (s *BoltStorage) ConnectBlocks(blocks []*Block, txs [][]*Tx, checkpoint *Checkpoint) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		for i, block := range blocks {
+			if err := connectBlock(btx, block, txs[i]); err != nil {
+				return err
+			}
+		}
+
+		return putCheckpoint(btx, checkpoint)
+	})
+}
+
+// DisconnectBlock removes the block from the main chain: its height, txs,
+// spent outputs and address entries are unindexed, and the block is recorded
+// as orphaned. The block and its txs are kept so that they can still be
+// viewed. The best block becomes the parent of the block, if any.
+func (s *BoltStorage) DisconnectBlock(block *Block) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		txs, err := findBlockTxs(btx, block.Hash)
+		if err != nil {
+			return err
+		}
+
+		if err := removeAddressEntries(btx, block, txs); err != nil {
+			return err
+		}
+
+		parent, err := findBlock(btx, block.PrevBlock)
+		if err != nil && err != ErrBlockNotFound {
+			return err
+		}
+
+		if err := updateChainStats(btx, func(stats *ChainStats) {
+			stats.DisconnectTxs(txs)
+			stats.DisconnectBlock(block, parent)
+		}); err != nil {
+			return err
+		}
+
+		if err := updateActivity(btx, block, parent, txs, -1); err != nil {
+			return err
+		}
+
+		for _, tx := range txs {
+			for _, input := range tx.Inputs {
+				key := outpointKey(input.PreviousOutput)
+
+				var spentBy *SpentBy
+				if spentByBytes := btx.Bucket(spentOutputsBucket).Get(key); spentByBytes != nil {
+					if err := json.Unmarshal(spentByBytes, &spentBy); err != nil {
+						return err
+					}
+				}
+
+				if spentBy != nil && spentBy.TxHash == tx.Hash {
+					if err := btx.Bucket(spentOutputsBucket).Delete(key); err != nil {
+						return err
+					}
+				}
+			}
+
+			var location *TxLocation
+			if locationBytes := btx.Bucket(txToBlockBucket).Get([]byte(tx.Hash)); locationBytes != nil {
+				if err := json.Unmarshal(locationBytes, &location); err != nil {
+					return err
+				}
+			}
+
+			if location != nil && location.BlockHash == block.Hash {
+				if err := btx.Bucket(txToBlockBucket).Delete([]byte(tx.Hash)); err != nil {
+					return err
+				}
+			}
+		}
+
+		if string(btx.Bucket(heightToBlockBucket).Get(heightKey(block.Height))) == block.Hash {
+			if err := btx.Bucket(heightToBlockBucket).Delete(heightKey(block.Height)); err != nil {
+				return err
+			}
+		}
+
+		orphanedAt := make([]byte, 8)
+		binary.BigEndian.PutUint64(orphanedAt, uint64(time.Now().Unix()))
+
+		if err := btx.Bucket(orphanBlocksBucket).Put([]byte(block.Hash), orphanedAt); err != nil {
+			return err
+		}
+
+		// Without the genesis block, the chain is empty and has no best
+		// block.
+		if block.Height == 0 {
+			if err := btx.Bucket(statsBucket).Delete(syncCheckpointKey); err != nil {
+				return err
+			}
+
+			return btx.Bucket(statsBucket).Delete(bestBlockHashKey)
+		}
+
+		if err := putCheckpoint(btx, &Checkpoint{
+			Height:     block.Height - 1,
+			Hash:       block.PrevBlock,
+			TargetHash: block.PrevBlock,
+		}); err != nil {
+			return err
+		}
+
+		return btx.Bucket(statsBucket).Put(bestBlockHashKey, []byte(block.PrevBlock))
+	})
+}
+
+func (s *BoltStorage) FindCheckpoint() (checkpoint *Checkpoint, err error) {
+	err = s.db.View(func(btx *bolt.Tx) error {
+		checkpointBytes := btx.Bucket(statsBucket).Get(syncCheckpointKey)
+		if checkpointBytes == nil {
+			return ErrCheckpointNotFound
+		}
+
+		return json.Unmarshal(checkpointBytes, &checkpoint)
+	})
+
+	return
+}
+
+func (s *BoltStorage) FindChainStats() (stats *ChainStats, err error) {
+	err = s.db.View(func(btx *bolt.Tx) error {
+		stats, err = findChainStats(btx)
+
+		return err
+	})
+
+	return
+}
+
+func (s *BoltStorage) StoreHeaders(headers []*Block, txs [][]*Tx) error {
+	return s.db.Update(func(btx *bolt.Tx) error {
+		for i, header := range headers {
+			headerBytes, err := json.Marshal(header)
+			if err != nil {
+				return err
+			}
+
+			if err := btx.Bucket(headersBucket).Put([]byte(header.Hash), headerBytes); err != nil {
+				return err
+			}
+
+			txsBytes, err := json.Marshal(txs[i])
+			if err != nil {
+				return err
+			}
+
+			if err := btx.Bucket(headerTxsBucket).Put([]byte(header.Hash), txsBytes); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BoltStorage) PruneHeaders(keep []*Block) error {
+	keepHashes := make(map[string]struct{}, len(keep))
+	for _, header := range keep {
+		keepHashes[header.Hash] = struct{}{}
+	}
+
+	return s.db.Update(func(btx *bolt.Tx) error {
+		var pruned [][]byte
+
+		if err := btx.Bucket(headersBucket).ForEach(func(k, v []byte) error {
+			if _, ok := keepHashes[string(k)]; !ok {
+				pruned = append(pruned, append([]byte(nil), k...))
+			}
+
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, hash := range pruned {
+			if err := btx.Bucket(headersBucket).Delete(hash); err != nil {
+				return err
+			}
+
+			if err := btx.Bucket(headerTxsBucket).Delete(hash); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *BoltStorage) FindHeader(hash string) (header *Block, err error) {
+	err = s.db.View(func(btx *bolt.Tx) error {
+		headerBytes := btx.Bucket(headersBucket).Get([]byte(hash))
+		if headerBytes == nil {
+			return ErrBlockNotFound
+		}
+
+		return json.Unmarshal(headerBytes, &header)
+	})
+
+	return
+}
+
+func (s *BoltStorage) FindHeaderTxs(hash string) (txs []*Tx, err error) {
+	err = s.db.View(func(btx *bolt.Tx) error {
+		txsBytes := btx.Bucket(headerTxsBucket).Get([]byte(hash))
+		if txsBytes == nil {
+			return ErrBlockNotFound
+		}
+
+		return json.Unmarshal(txsBytes, &txs)
+	})
+
+	return
+}
+
+// IsInMainChain reports whether the block is stored and part of the main
+// chain.
+func (s *BoltStorage) IsInMainChain(hash string) (bool, error) {
+	var inMainChain bool
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		blockBytes := tx.Bucket(blocksBucket).Get([]byte(hash))
+		if blockBytes == nil {
+			return nil
+		}
+
+		var block *Block
+		if err := json.Unmarshal(blockBytes, &block); err != nil {
+			return err
+		}
+
+		inMainChain = string(tx.Bucket(heightToBlockBucket).Get(heightKey(block.Height))) == hash
+
+		return nil
+	}); err != nil {
+		return false, err
+	}
+
+	return inMainChain, nil
+}
+
+func (s *BoltStorage) IsOrphanBlock(hash string) (bool, error) {
+	var orphan bool
+
+	if err := s.db.View(func(tx *bolt.Tx) error {
+		orphan = tx.Bucket(orphanBlocksBucket).Get([]byte(hash)) != nil
+
+		return nil
+	}); err != nil {
+		return false, err
+	}
+
+	return orphan, nil
+}
+
+// findKeysByPrefix seeks the bucket for the keys starting with prefix.
+func (s *BoltStorage) findKeysByPrefix(bucket []byte, prefix string, limit int) ([]string, error) {
+	keys := []string{}
+
+	err := s.db.View(func(btx *bolt.Tx) error {
+		c := btx.Bucket(bucket).Cursor()
+
+		for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)) && len(keys) < limit; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+
+		return nil
+	})
+
+	return keys, err
+}
+
+func (s *BoltStorage) FindBlockHashesByPrefix(prefix string, limit int) ([]string, error) {
+	return s.findKeysByPrefix(blocksBucket, prefix, limit)
+}
+
+func (s *BoltStorage) FindTxHashesByPrefix(prefix string, limit int) ([]string, error) {
+	return s.findKeysByPrefix(txsBucket, prefix, limit)
+}
+
+func (s *BoltStorage) FindAddressesByPrefix(prefix string, limit int) ([]string, error) {
+	return s.findKeysByPrefix(addressesBucket, prefix, limit)
+}
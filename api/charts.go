@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/csv"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxChartPoints = 1000
+
+	defaultHashrateWindow = 144
+	maxHashrateWindow     = 10000
+
+	// defaultActivityBuckets is the number of buckets served when from is
+	// not given.
+	defaultActivityBuckets = 30
+)
+
+const (
+	ChartFormatJson = "json"
+	ChartFormatCsv  = "csv"
+)
+
+// activityMetrics compute the metrics charted from the activity buckets. A
+// metric is not charted for a bucket when its second result is false.
+var activityMetrics = map[string]func(bucket *ActivityBucket) (float64, bool){
+	"txs": func(bucket *ActivityBucket) (float64, bool) {
+		return float64(bucket.Txs), true
+	},
+	"output_volume": func(bucket *ActivityBucket) (float64, bool) {
+		return float64(bucket.OutputVolume), true
+	},
+	"block_interval": func(bucket *ActivityBucket) (float64, bool) {
+		if bucket.Intervals == 0 {
+			return 0, false
+		}
+
+		return float64(bucket.TotalInterval) / float64(bucket.Intervals), true
+	},
+	"block_size": func(bucket *ActivityBucket) (float64, bool) {
+		if bucket.Blocks == 0 {
+			return 0, false
+		}
+
+		return float64(bucket.TotalSize) / float64(bucket.Blocks), true
+	},
+	"active_addresses": func(bucket *ActivityBucket) (float64, bool) {
+		return float64(bucket.ActiveAddresses), true
+	},
+}
+
+type ChartPoint struct {
+	Height    uint32  `json:"height"`
+	Timestamp uint64  `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type ActivityPoint struct {
+	Timestamp uint64  `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+func formatChartValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func parseChartFormat(c *gin.Context) (string, bool) {
+	format := c.DefaultQuery("format", ChartFormatJson)
+	if format != ChartFormatJson && format != ChartFormatCsv {
+		abortWithError(c, http.StatusBadRequest, "invalid_format", "format must be json or csv")
+		return "", false
+	}
+
+	return format, true
+}
+
+func writeCsv(c *gin.Context, header []string, rows [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+
+	if err := w.Write(header); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	// WriteAll flushes the rows and returns the first error of the writer.
+	if err := w.WriteAll(rows); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+// renderChartPoints writes the points of a block chart, along with its
+// parameters in JSON.
+func renderChartPoints(c *gin.Context, format string, points []*ChartPoint, body gin.H) {
+	if format == ChartFormatCsv {
+		var rows [][]string
+		for _, point := range points {
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(point.Height), 10),
+				strconv.FormatUint(point.Timestamp, 10),
+				formatChartValue(point.Value),
+			})
+		}
+
+		writeCsv(c, []string{"height", "timestamp", "value"}, rows)
+		return
+	}
+
+	body["points"] = points
+
+	c.JSON(http.StatusOK, body)
+}
+
+// handleChart serves the metric named in the path, either computed per block
+// or aggregated per hour or per day.
+func (a *Api) handleChart(c *gin.Context) {
+	switch metric := c.Param("metric"); metric {
+	case "difficulty":
+		a.handleDifficultyChart(c)
+	case "hashrate":
+		a.handleHashrateChart(c)
+	default:
+		value, ok := activityMetrics[metric]
+		if !ok {
+			abortWithError(c, http.StatusNotFound, "metric_not_found", "no chart is named "+metric)
+			return
+		}
+
+		a.handleActivityChart(c, value)
+	}
+}
+
+func parseTimestamp(c *gin.Context, name string, defaultValue uint64) (uint64, bool) {
+	query, ok := c.GetQuery(name)
+	if !ok {
+		return defaultValue, true
+	}
+
+	timestamp, err := strconv.ParseUint(query, 10, 64)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "invalid_"+name, name+" must be a unix timestamp")
+		return 0, false
+	}
+
+	return timestamp, true
+}
+
+// handleActivityChart serves an activity metric over the buckets of the
+// interval starting from from to to, unix timestamps.
+func (a *Api) handleActivityChart(c *gin.Context, value func(*ActivityBucket) (float64, bool)) {
+	format, ok := parseChartFormat(c)
+	if !ok {
+		return
+	}
+
+	interval := c.DefaultQuery("interval", ActivityIntervalDay)
+	seconds, ok := activityIntervals[interval]
+	if !ok {
+		abortWithError(c, http.StatusBadRequest, "invalid_interval", "interval must be hour or day")
+		return
+	}
+
+	to, ok := parseTimestamp(c, "to", uint64(time.Now().Unix()))
+	if !ok {
+		return
+	}
+
+	defaultFrom := uint64(0)
+	if to >= defaultActivityBuckets*seconds {
+		defaultFrom = to - defaultActivityBuckets*seconds
+	}
+
+	from, ok := parseTimestamp(c, "from", defaultFrom)
+	if !ok {
+		return
+	}
+
+	from = activityBucketStart(interval, from)
+
+	if from > to || (to-from)/seconds >= maxChartPoints {
+		abortWithError(c, http.StatusBadRequest, "invalid_range", "from must be before to, and at most "+strconv.Itoa(maxChartPoints)+" buckets apart")
+		return
+	}
+
+	buckets, err := a.storage.FindActivityBuckets(interval, from, to)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	points := []*ActivityPoint{}
+
+	for _, bucket := range buckets {
+		if v, ok := value(bucket); ok {
+			points = append(points, &ActivityPoint{
+				Timestamp: bucket.Start,
+				Value:     v,
+			})
+		}
+	}
+
+	if format == ChartFormatCsv {
+		var rows [][]string
+		for _, point := range points {
+			rows = append(rows, []string{
+				strconv.FormatUint(point.Timestamp, 10),
+				formatChartValue(point.Value),
+			})
+		}
+
+		writeCsv(c, []string{"timestamp", "value"}, rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"interval": interval,
+		"from":     from,
+		"to":       to,
+		"points":   points,
+	})
+}
+
+// chartResolution parses the resolution, the number of blocks between two
+// points, the last point being the best block. It defaults to the finest
+// resolution giving at most maxChartPoints points, and is at most the number
+// of blocks. The best block is nil if the chain is empty.
+func (a *Api) chartResolution(c *gin.Context) (*Block, uint32, bool) {
+	bestBlock, err := a.findBestBlock()
+	if err == ErrBestBlockHashNotFound {
+		return nil, 1, true
+	}
+	if err != nil {
+		abortWithInternalError(c, err)
+		return nil, 0, false
+	}
+
+	blocks := int(bestBlock.Height) + 1
+
+	minResolution := (blocks + maxChartPoints - 1) / maxChartPoints
+
+	resolution, err := strconv.Atoi(c.DefaultQuery("resolution", strconv.Itoa(minResolution)))
+	if err != nil || resolution < minResolution || resolution > blocks {
+		abortWithError(c, http.StatusBadRequest, "invalid_resolution", "resolution must be an integer between "+strconv.Itoa(minResolution)+" and "+strconv.Itoa(blocks))
+		return nil, 0, false
+	}
+
+	return bestBlock, uint32(resolution), true
+}
+
+// chartHeights returns the heights of the points of a chart, every resolution
+// blocks up to the best block.
+func chartHeights(bestBlock *Block, resolution uint32) []uint32 {
+	var heights []uint32
+
+	for height := bestBlock.Height % resolution; ; height += resolution {
+		heights = append(heights, height)
+
+		if bestBlock.Height-height < resolution {
+			return heights
+		}
+	}
+}
+
+// handleDifficultyChart serves the difficulty of the main chain blocks.
+func (a *Api) handleDifficultyChart(c *gin.Context) {
+	format, ok := parseChartFormat(c)
+	if !ok {
+		return
+	}
+
+	bestBlock, resolution, ok := a.chartResolution(c)
+	if !ok {
+		return
+	}
+
+	points := []*ChartPoint{}
+
+	if bestBlock != nil {
+		heights := chartHeights(bestBlock, resolution)
+
+		// Only the blocks of the points are read, at once so that the
+		// chart is consistent even if the main chain changes meanwhile.
+		blocks, err := a.storage.FindBlocksByHeights(heights)
+		if err != nil {
+			abortWithInternalError(c, err)
+			return
+		}
+
+		for _, height := range heights {
+			block, ok := blocks[height]
+			if !ok || block.Stats == nil {
+				continue
+			}
+
+			points = append(points, &ChartPoint{
+				Height:    block.Height,
+				Timestamp: block.Timestamp,
+				Value:     block.Stats.Difficulty,
+			})
+		}
+	}
+
+	renderChartPoints(c, format, points, gin.H{
+		"resolution": resolution,
+	})
+}
+
+// handleHashrateChart serves the network hashrate, in hashes per second,
+// estimated at every point from the work and the time spent on the last
+// window blocks.
+func (a *Api) handleHashrateChart(c *gin.Context) {
+	format, ok := parseChartFormat(c)
+	if !ok {
+		return
+	}
+
+	window, err := strconv.Atoi(c.DefaultQuery("window", strconv.Itoa(defaultHashrateWindow)))
+	if err != nil || window < 1 || window > maxHashrateWindow {
+		abortWithError(c, http.StatusBadRequest, "invalid_window", "window must be an integer between 1 and "+strconv.Itoa(maxHashrateWindow))
+		return
+	}
+
+	bestBlock, resolution, ok := a.chartResolution(c)
+	if !ok {
+		return
+	}
+
+	points := []*ChartPoint{}
+
+	if bestBlock != nil {
+		windowStart := func(height uint32) uint32 {
+			if height > uint32(window) {
+				return height - uint32(window)
+			}
+
+			return 0
+		}
+
+		heights := chartHeights(bestBlock, resolution)
+
+		// Only the blocks of the points and the blocks starting their
+		// window are read, at once so that the chart is consistent even
+		// if the main chain changes meanwhile.
+		var readHeights []uint32
+		for _, height := range heights {
+			readHeights = append(readHeights, windowStart(height), height)
+		}
+
+		blocks, err := a.storage.FindBlocksByHeights(readHeights)
+		if err != nil {
+			abortWithInternalError(c, err)
+			return
+		}
+
+		for _, height := range heights {
+			from, fromOk := blocks[windowStart(height)]
+			to, toOk := blocks[height]
+			if height == 0 || !fromOk || !toOk {
+				continue
+			}
+
+			points = append(points, &ChartPoint{
+				Height:    to.Height,
+				Timestamp: to.Timestamp,
+				Value:     EstimateHashrate(from, to),
+			})
+		}
+	}
+
+	renderChartPoints(c, format, points, gin.H{
+		"resolution": resolution,
+		"window":     window,
+	})
+}
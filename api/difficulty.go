@@ -0,0 +1,70 @@
+package main
+
+import (
+	"math/big"
+)
+
+// genesisTarget is the target of the genesis block, the easiest target of the
+// network, whose difficulty is 1.
+var genesisTarget = new(big.Int).Lsh(big.NewInt(15), 232)
+
+// TargetToDifficulty returns how many times harder than the genesis block it
+// is to find a block under the target, a hex encoded 256 bits integer.
+func TargetToDifficulty(target string) float64 {
+	targetInt, ok := new(big.Int).SetString(target, 16)
+	if !ok || targetInt.Sign() == 0 {
+		return 0
+	}
+
+	difficulty, _ := new(big.Float).Quo(new(big.Float).SetInt(genesisTarget), new(big.Float).SetInt(targetInt)).Float64()
+
+	return difficulty
+}
+
+// twoTo256 is the number of possible hashes.
+var twoTo256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// TargetToWork returns the expected number of hashes needed to find a block
+// under the target: 2^256 / (target + 1).
+func TargetToWork(target string) *big.Int {
+	targetInt, ok := new(big.Int).SetString(target, 16)
+	if !ok {
+		return new(big.Int)
+	}
+
+	return new(big.Int).Quo(twoTo256, targetInt.Add(targetInt, big.NewInt(1)))
+}
+
+// chainWork returns the hex encoded expected number of hashes needed to build
+// the chain up to a block of the given target, on top of parent. A nil parent
+// stands for the genesis block.
+func chainWork(parent *Block, target string) string {
+	work := TargetToWork(target)
+
+	if parent != nil && parent.Stats != nil {
+		if parentWork, ok := new(big.Int).SetString(parent.Stats.ChainWork, 16); ok {
+			work.Add(work, parentWork)
+		}
+	}
+
+	return work.Text(16)
+}
+
+// EstimateHashrate returns the number of hashes per second computed by the
+// network to build the chain from one block to another, 0 if it is unknown.
+func EstimateHashrate(from *Block, to *Block) float64 {
+	if from.Stats == nil || to.Stats == nil || to.Timestamp <= from.Timestamp {
+		return 0
+	}
+
+	fromWork, fromOk := new(big.Int).SetString(from.Stats.ChainWork, 16)
+	toWork, toOk := new(big.Int).SetString(to.Stats.ChainWork, 16)
+	if !fromOk || !toOk {
+		return 0
+	}
+
+	work := new(big.Float).SetInt(new(big.Int).Sub(toWork, fromWork))
+	hashrate, _ := work.Quo(work, new(big.Float).SetUint64(to.Timestamp-from.Timestamp)).Float64()
+
+	return hashrate
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var (
+	maxTarget  = strings.Repeat("f", 64)
+	halfTarget = "7" + strings.Repeat("f", 63)
+)
+
+func TestTargetToWork(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		work   string
+	}{
+		{"max target", maxTarget, "1"},
+		{"half target", halfTarget, "2"},
+		{"genesis target", genesisTarget.Text(16), "111111"},
+		{"zero target", "0", "1" + strings.Repeat("0", 64)},
+		{"invalid target", "zz", "0"},
+		{"empty target", "", "0"},
+	}
+
+	for _, test := range tests {
+		if work := TargetToWork(test.target).Text(16); work != test.work {
+			t.Errorf("%s: TargetToWork() = %s, want %s", test.name, work, test.work)
+		}
+	}
+}
+
+func TestChainWork(t *testing.T) {
+	tests := []struct {
+		name   string
+		parent *Block
+		target string
+		work   string
+	}{
+		{"genesis", nil, maxTarget, "1"},
+		{"parent", &Block{Stats: &BlockStats{ChainWork: "10"}}, halfTarget, "12"},
+		{"parent without stats", &Block{}, halfTarget, "2"},
+		{"parent with an invalid chain work", &Block{Stats: &BlockStats{ChainWork: "zz"}}, halfTarget, "2"},
+		{"invalid target", &Block{Stats: &BlockStats{ChainWork: "10"}}, "zz", "10"},
+	}
+
+	for _, test := range tests {
+		if work := chainWork(test.parent, test.target); work != test.work {
+			t.Errorf("%s: chainWork() = %s, want %s", test.name, work, test.work)
+		}
+	}
+}
+
+func TestEstimateHashrate(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     *Block
+		to       *Block
+		hashrate float64
+	}{
+		{
+			"hashrate",
+			&Block{Timestamp: 1000, Stats: &BlockStats{ChainWork: "10"}},
+			&Block{Timestamp: 1100, Stats: &BlockStats{ChainWork: "3f8"}},
+			10,
+		},
+		{
+			"same timestamp",
+			&Block{Timestamp: 1000, Stats: &BlockStats{ChainWork: "10"}},
+			&Block{Timestamp: 1000, Stats: &BlockStats{ChainWork: "3f8"}},
+			0,
+		},
+		{
+			"earlier timestamp",
+			&Block{Timestamp: 1100, Stats: &BlockStats{ChainWork: "10"}},
+			&Block{Timestamp: 1000, Stats: &BlockStats{ChainWork: "3f8"}},
+			0,
+		},
+		{
+			"from without stats",
+			&Block{Timestamp: 1000},
+			&Block{Timestamp: 1100, Stats: &BlockStats{ChainWork: "3f8"}},
+			0,
+		},
+		{
+			"to without stats",
+			&Block{Timestamp: 1000, Stats: &BlockStats{ChainWork: "10"}},
+			&Block{Timestamp: 1100},
+			0,
+		},
+		{
+			"invalid chain work",
+			&Block{Timestamp: 1000, Stats: &BlockStats{ChainWork: "10"}},
+			&Block{Timestamp: 1100, Stats: &BlockStats{ChainWork: "zz"}},
+			0,
+		},
+	}
+
+	for _, test := range tests {
+		if hashrate := EstimateHashrate(test.from, test.to); hashrate != test.hashrate {
+			t.Errorf("%s: EstimateHashrate() = %v, want %v", test.name, hashrate, test.hashrate)
+		}
+	}
+}
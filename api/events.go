@@ -0,0 +1,171 @@
+package main
+
+import (
+	"sync"
+)
+
+// eventBufferSize is the number of events a subscriber can lag behind before
+// being dropped.
+const eventBufferSize = 256
+
+// maxTxEventsBlocks is the number of blocks connected at once above which the
+// synchronizer is catching up: the tx_confirmed events are then not published,
+// they would overflow the buffers of the subscribers.
+const maxTxEventsBlocks = 16
+
+const (
+	EventBlockConnected    = "block_connected"
+	EventBlockDisconnected = "block_disconnected"
+	EventTxConfirmed       = "tx_confirmed"
+)
+
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+type TxEvent struct {
+	Tx          *Tx    `json:"tx"`
+	BlockHash   string `json:"block_hash"`
+	BlockHeight uint32 `json:"block_height"`
+	// addresses are the addresses credited or debited by the tx, resolved
+	// once for all the subscribers.
+	addresses map[string]struct{}
+}
+
+// EventBus broadcasts the chain events to its subscribers. Publishing never
+// blocks: a subscriber too slow to keep up has its channel closed.
+type EventBus struct {
+	mutex sync.Mutex
+	// subscribers are the channels of the subscribers, along with the filter
+	// of the events they receive.
+	subscribers map[chan *Event]func(*Event) bool
+	closed      bool
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[chan *Event]func(*Event) bool),
+	}
+}
+
+// Subscribe returns a channel receiving the events filter accepts, or every
+// event if filter is nil. The events are filtered as they are published, so
+// that the events a subscriber does not want do not fill its buffer; filter
+// must not block.
+func (b *EventBus) Subscribe(filter func(*Event) bool) chan *Event {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	events := make(chan *Event, eventBufferSize)
+
+	if b.closed {
+		close(events)
+	} else {
+		b.subscribers[events] = filter
+	}
+
+	return events
+}
+
+func (b *EventBus) Unsubscribe(events chan *Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.subscribers[events]; ok {
+		delete(b.subscribers, events)
+		close(events)
+	}
+}
+
+// Close closes the channels of all the subscribers.
+func (b *EventBus) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for events := range b.subscribers {
+		delete(b.subscribers, events)
+		close(events)
+	}
+
+	b.closed = true
+}
+
+func (b *EventBus) Publish(event *Event) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for events, filter := range b.subscribers {
+		if filter != nil && !filter(event) {
+			continue
+		}
+
+		select {
+		case events <- event:
+		default:
+			delete(b.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+func (b *EventBus) publishBlockConnected(block *Block, txEvents []*TxEvent) {
+	b.Publish(&Event{
+		Type: EventBlockConnected,
+		Data: newBlockSummary(block),
+	})
+
+	for _, txEvent := range txEvents {
+		b.Publish(&Event{
+			Type: EventTxConfirmed,
+			Data: txEvent,
+		})
+	}
+}
+
+func (b *EventBus) publishBlockDisconnected(block *Block) {
+	b.Publish(&Event{
+		Type: EventBlockDisconnected,
+		Data: newBlockSummary(block),
+	})
+}
+
+// newTxEvents returns the tx_confirmed events of the txs of a connected block.
+// The txs are copied before their scripts are decoded, as they are shared with
+// the caller.
+func (s *Synchronizer) newTxEvents(block *Block, txs []*Tx) ([]*TxEvent, error) {
+	var txEvents []*TxEvent
+
+	for _, tx := range txs {
+		txEvent := &TxEvent{
+			Tx:          copyTx(tx),
+			BlockHash:   block.Hash,
+			BlockHeight: block.Height,
+			addresses:   make(map[string]struct{}),
+		}
+
+		DecodeScripts(txEvent.Tx)
+
+		for _, output := range tx.Outputs {
+			txEvent.addresses[ScriptToAddress(output.Script)] = struct{}{}
+		}
+
+		for _, input := range tx.Inputs {
+			previousTx, err := s.storage.FindTxByHash(input.PreviousOutput.Hash)
+			if err == ErrTxNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			if int(input.PreviousOutput.Index) < len(previousTx.Outputs) {
+				txEvent.addresses[ScriptToAddress(previousTx.Outputs[input.PreviousOutput.Index].Script)] = struct{}{}
+			}
+		}
+
+		txEvents = append(txEvents, txEvent)
+	}
+
+	return txEvents, nil
+}
@@ -6,8 +6,9 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/toorop/gin-logrus"
+	"io"
 	"net/http"
-	"strconv"
+	"os"
 	"time"
 )
 
@@ -16,17 +17,34 @@ var rootCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Info("ensicoin explorer version 0.0.0")
 
-		dbPath, err := cmd.Flags().GetString("dbpath")
+		rpcServerAddress, err := cmd.Flags().GetString("rpcserver")
 		if err != nil {
-			log.WithError(err).Fatal("fatal error reading the database path")
+			log.WithError(err).Fatal("fatal error reading the rpc server address")
 		}
 
-		rpcServerAddress, err := cmd.Flags().GetString("rpcserver")
+		storageBackend, err := cmd.Flags().GetString("storage")
 		if err != nil {
-			log.WithError(err).Fatal("fatal error reading the rpc server address")
+			log.WithError(err).Fatal("fatal error reading the storage backend")
+		}
+
+		poolTagsPath, err := cmd.Flags().GetString("pooltags")
+		if err != nil {
+			log.WithError(err).Fatal("fatal error reading the pool tags path")
+		}
+
+		dbPath := databasePath(cmd, storageBackend)
+
+		var storage Store
+
+		switch storageBackend {
+		case "bolt":
+			storage = NewBoltStorage(dbPath)
+		case "sqlite":
+			storage = NewSqlStorage(dbPath)
+		default:
+			log.WithField("storage", storageBackend).Fatal("unknown storage backend")
 		}
 
-		storage := NewStorage(dbPath)
 		if err := storage.Open(); err != nil {
 			log.WithError(err).Fatal("fatal error opening the database")
 		}
@@ -36,33 +54,36 @@ var rootCmd = &cobra.Command{
 		if err := synchronizer.Start(); err != nil {
 			log.WithError(err).Fatal("fatal error starting the synchronizer")
 		}
+		defer synchronizer.Stop()
 
 		r := gin.Default()
 		r.Use(ginlogrus.Logger(log.StandardLogger()), gin.Recovery())
 
-		r.GET("/blocks", func(c *gin.Context) {
-			rawPage := c.DefaultQuery("page", "0")
-			rawLimit := c.DefaultQuery("limit", "10")
+		var poolTags *PoolTags
 
-			page, err := strconv.Atoi(rawPage)
+		if poolTagsPath != "" {
+			poolTags, err = LoadPoolTags(poolTagsPath)
 			if err != nil {
-				c.Status(http.StatusBadRequest)
-				return
-			}
-
-			limit, err := strconv.Atoi(rawLimit)
-			if err != nil {
-				c.Status(http.StatusBadRequest)
-				return
+				log.WithError(err).Fatal("fatal error loading the pool tags")
 			}
+		}
 
-			_ = page
-			_ = limit
+		api := NewApi(storage, synchronizer, poolTags)
 
-			c.JSON(http.StatusOK, gin.H{
-				"message": "pong",
-			})
-		})
+		r.GET("/status", api.handleStatus)
+		r.GET("/stats", api.handleStats)
+		r.GET("/blocks", api.handleBlocks)
+		r.GET("/blocks/:id", api.handleBlock)
+		r.GET("/txs/:hash", api.handleTx)
+		r.POST("/txs/broadcast", api.handleBroadcastTx)
+		r.GET("/addresses/:addr", api.handleAddress)
+		r.GET("/search", api.handleSearch)
+		r.GET("/miners", api.handleMiners)
+		r.GET("/charts/:metric", api.handleChart)
+		r.GET("/mempool", api.handleMempool)
+		r.GET("/mempool/:hash", api.handleMempoolTx)
+		r.GET("/events", api.handleEvents)
+		r.GET("/ws", api.handleWebSocket)
 
 		srv := &http.Server{
 			Addr:    ":8080",
@@ -88,9 +109,184 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// defaultDbPaths are the database paths of the storage backends when no
+// path is given, so that a backend does not open the file of the other one.
+var defaultDbPaths = map[string]string{
+	"bolt":   "database/data.db",
+	"sqlite": "database/data.sqlite",
+}
+
+// sqliteHeader starts every SQLite database file.
+const sqliteHeader = "SQLite format 3\x00"
+
+// databasePath returns the database path of the storage backend, exiting if
+// the backend is unknown or if the database is a file of the other backend.
+func databasePath(cmd *cobra.Command, storageBackend string) string {
+	dbPath, err := cmd.Flags().GetString("dbpath")
+	if err != nil {
+		log.WithError(err).Fatal("fatal error reading the database path")
+	}
+
+	defaultDbPath, ok := defaultDbPaths[storageBackend]
+	if !ok {
+		log.WithField("storage", storageBackend).Fatal("unknown storage backend")
+	}
+
+	if dbPath == "" {
+		dbPath = defaultDbPath
+	}
+
+	file, err := os.Open(dbPath)
+	if os.IsNotExist(err) {
+		return dbPath
+	}
+	if err != nil {
+		log.WithError(err).Fatal("fatal error opening the database")
+	}
+	defer file.Close()
+
+	header := make([]byte, len(sqliteHeader))
+
+	n, err := io.ReadFull(file, header)
+	if err == io.EOF {
+		return dbPath
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		log.WithError(err).Fatal("fatal error reading the database")
+	}
+
+	if isSqlite := string(header[:n]) == sqliteHeader; isSqlite != (storageBackend == "sqlite") {
+		log.WithFields(log.Fields{
+			"dbpath":  dbPath,
+			"storage": storageBackend,
+		}).Fatal("the database was not created by the storage backend, set --storage or --dbpath")
+	}
+
+	return dbPath
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the database to the latest schema version",
+	Run: func(cmd *cobra.Command, args []string) {
+		storageBackend, err := cmd.Flags().GetString("storage")
+		if err != nil {
+			log.WithError(err).Fatal("fatal error reading the storage backend")
+		}
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			log.WithError(err).Fatal("fatal error reading the dry run flag")
+		}
+
+		dbPath := databasePath(cmd, storageBackend)
+
+		switch storageBackend {
+		case "bolt":
+			migrateBolt(dbPath, dryRun)
+		case "sqlite":
+			migrateSql(dbPath, dryRun)
+		default:
+			log.WithField("storage", storageBackend).Fatal("unknown storage backend")
+		}
+	},
+}
+
+func migrateBolt(dbPath string, dryRun bool) {
+	storage := NewBoltStorage(dbPath)
+	if err := storage.open(); err != nil {
+		log.WithError(err).Fatal("fatal error opening the database")
+	}
+	defer storage.Close()
+
+	version, err := storage.SchemaVersion()
+	if err != nil {
+		log.WithError(err).Fatal("fatal error reading the schema version")
+	}
+
+	pending, err := storage.PendingMigrations()
+	if err != nil {
+		log.WithError(err).Fatal("fatal error listing the pending migrations")
+	}
+
+	log.WithFields(log.Fields{
+		"version":       version,
+		"latestVersion": latestSchemaVersion(),
+		"pending":       len(pending),
+	}).Info("schema version")
+
+	for _, migration := range pending {
+		log.WithFields(log.Fields{
+			"version":     migration.Version,
+			"description": migration.Description,
+		}).Info("pending migration")
+	}
+
+	if len(pending) == 0 {
+		return
+	}
+
+	if dryRun {
+		log.Info("dry run: running the pending migrations without committing them")
+	}
+
+	if err := storage.Migrate(dryRun, logMigrationProgress); err != nil {
+		log.WithError(err).Fatal("fatal error migrating the database")
+	}
+
+	if !dryRun {
+		log.WithField("version", latestSchemaVersion()).Info("migrated")
+	}
+}
+
+func migrateSql(dbPath string, dryRun bool) {
+	storage := NewSqlStorage(dbPath)
+	if err := storage.open(); err != nil {
+		log.WithError(err).Fatal("fatal error opening the database")
+	}
+	defer storage.Close()
+
+	version, err := storage.SchemaVersion()
+	if err != nil {
+		log.WithError(err).Fatal("fatal error reading the schema version")
+	}
+
+	pending := 0
+	if version < len(sqlMigrations) {
+		pending = len(sqlMigrations) - version
+	}
+
+	log.WithFields(log.Fields{
+		"version":       version,
+		"latestVersion": len(sqlMigrations),
+		"pending":       pending,
+	}).Info("schema version")
+
+	if pending == 0 {
+		return
+	}
+
+	if dryRun {
+		log.Info("dry run: running the pending migrations without committing them")
+	}
+
+	if err := storage.Migrate(dryRun); err != nil {
+		log.WithError(err).Fatal("fatal error migrating the database")
+	}
+
+	if !dryRun {
+		log.WithField("version", len(sqlMigrations)).Info("migrated")
+	}
+}
+
 func init() {
-	rootCmd.Flags().String("dbpath", "database/data.db", "database path")
+	rootCmd.PersistentFlags().String("dbpath", "", "database path, database/data.db for bolt and database/data.sqlite for sqlite by default")
 	rootCmd.Flags().String("rpcserver", "localhost:4225", "RPC server to connect to")
+	rootCmd.PersistentFlags().String("storage", "bolt", "storage backend, bolt or sqlite")
+	rootCmd.Flags().String("pooltags", "", "JSON or YAML file naming the miners by payout script or coinbase tag")
+
+	migrateCmd.Flags().Bool("dry-run", false, "run the migrations without committing them")
+	rootCmd.AddCommand(migrateCmd)
 }
 
 func main() {
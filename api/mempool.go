@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type MempoolTx struct {
+	Tx         *Tx       `json:"tx"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// Mempool holds the txs pending in the node, as announced by its block
+// templates, along with the latest template.
+type Mempool struct {
+	mutex             sync.RWMutex
+	txs               map[string]*MempoolTx
+	template          *BlockTemplate
+	templateTxs       []*Tx
+	templateUpdatedAt time.Time
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{
+		txs: make(map[string]*MempoolTx),
+	}
+}
+
+// Update replaces the pending txs with the txs of a new block template,
+// keeping the time at which the txs already pending were received.
+func (m *Mempool) Update(template *BlockTemplate, txs []*Tx) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+
+	m.template = template
+	m.templateTxs = txs
+	m.templateUpdatedAt = now
+
+	pendingTxs := make(map[string]*MempoolTx)
+
+	for _, tx := range txs {
+		if pendingTx, ok := m.txs[tx.Hash]; ok {
+			pendingTxs[tx.Hash] = pendingTx
+			continue
+		}
+
+		DecodeScripts(tx)
+
+		pendingTxs[tx.Hash] = &MempoolTx{
+			Tx:         tx,
+			ReceivedAt: now,
+		}
+	}
+
+	m.txs = pendingTxs
+}
+
+// RemoveTxs removes the txs confirmed by a block.
+func (m *Mempool) RemoveTxs(txs []*Tx) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, tx := range txs {
+		delete(m.txs, tx.Hash)
+	}
+}
+
+func (m *Mempool) Clear() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.txs = make(map[string]*MempoolTx)
+	m.template = nil
+	m.templateTxs = nil
+}
+
+// Template returns the latest block template, its txs and the time it was
+// received at, or a nil template if none was received.
+func (m *Mempool) Template() (*BlockTemplate, []*Tx, time.Time) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.template, m.templateTxs, m.templateUpdatedAt
+}
+
+func (m *Mempool) FindTx(hash string) (*MempoolTx, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	tx, ok := m.txs[hash]
+
+	return tx, ok
+}
+
+// Txs returns a page of the pending txs, the most recently received first,
+// along with the number of pending txs.
+func (m *Mempool) Txs(page int, limit int) ([]*MempoolTx, int) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	txs := make([]*MempoolTx, 0, len(m.txs))
+	for _, tx := range m.txs {
+		txs = append(txs, tx)
+	}
+
+	sort.Slice(txs, func(i, j int) bool {
+		if !txs[i].ReceivedAt.Equal(txs[j].ReceivedAt) {
+			return txs[i].ReceivedAt.After(txs[j].ReceivedAt)
+		}
+
+		return txs[i].Tx.Hash < txs[j].Tx.Hash
+	})
+
+	start := len(txs)
+	if page < len(txs) && page*limit < len(txs) {
+		start = page * limit
+	}
+
+	end := start + limit
+	if end > len(txs) {
+		end = len(txs)
+	}
+
+	return txs[start:end], len(txs)
+}
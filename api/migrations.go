@@ -0,0 +1,1323 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/EnsicoinDevs/eccd/network"
+	"github.com/EnsicoinDevs/eccd/utils"
+	"github.com/EnsicoinDevs/ensicoin-explorer/api/script"
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var schemaVersionKey = []byte("schemaVersion")
+
+// heightKeysMigratedKey was set by the height keys migration before the
+// schema was versioned.
+var heightKeysMigratedKey = []byte("heightKeysMigrated")
+
+var errDryRun = errors.New("dry run")
+
+// Migration upgrades the database from Version-1 to Version. Migrate reports
+// its progress through progress, as the number of items done out of total.
+type Migration struct {
+	Version     int
+	Description string
+	Migrate     func(tx *bolt.Tx, progress func(done int, total int)) error
+}
+
+// migrations must be kept sorted by version, and new migrations appended.
+var migrations = []*Migration{
+	{
+		Version:     1,
+		Description: "encode the height keys in big-endian",
+		Migrate:     migrateHeightKeys,
+	},
+	{
+		Version:     2,
+		Description: "rebuild the tx, spent output and address indexes",
+		Migrate:     rebuildIndexes,
+	},
+	{
+		Version:     3,
+		Description: "compute the chain statistics",
+		Migrate:     computeChainStats,
+	},
+	{
+		Version:     4,
+		Description: "compute the block and tx statistics",
+		Migrate:     computeBlockStats,
+	},
+	{
+		Version:     5,
+		Description: "decode the coinbase payouts",
+		Migrate:     computePayouts,
+	},
+	{
+		Version:     6,
+		Description: "compute the difficulty and the chain work",
+		Migrate:     computeChainWork,
+	},
+	{
+		Version:     7,
+		Description: "aggregate the hourly and daily activity",
+		Migrate:     computeActivity,
+	},
+	{
+		Version:     8,
+		Description: "aggregate the block intervals and the difficulty",
+		Migrate:     computeBlockIntervals,
+	},
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func schemaVersion(tx *bolt.Tx) int {
+	versionBytes := tx.Bucket(statsBucket).Get(schemaVersionKey)
+	if versionBytes == nil {
+		if tx.Bucket(statsBucket).Get(heightKeysMigratedKey) != nil {
+			return 1
+		}
+
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint32(versionBytes))
+}
+
+func putSchemaVersion(tx *bolt.Tx, version int) error {
+	versionBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(versionBytes, uint32(version))
+
+	if err := tx.Bucket(statsBucket).Delete(heightKeysMigratedKey); err != nil {
+		return err
+	}
+
+	return tx.Bucket(statsBucket).Put(schemaVersionKey, versionBytes)
+}
+
+func (s *BoltStorage) SchemaVersion() (version int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		version = schemaVersion(tx)
+
+		return nil
+	})
+
+	return
+}
+
+// PendingMigrations returns the migrations that have not been applied to the
+// database yet, in the order they must be applied.
+func (s *BoltStorage) PendingMigrations() ([]*Migration, error) {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []*Migration
+
+	for _, migration := range migrations {
+		if migration.Version > version {
+			pending = append(pending, migration)
+		}
+	}
+
+	return pending, nil
+}
+
+// Migrate applies the pending migrations, each one in its own transaction.
+// With dryRun set, every migration is run on the state left by the previous
+// ones in a single transaction, which is then rolled back so that the database
+// is left untouched.
+func (s *BoltStorage) Migrate(dryRun bool, progress func(migration *Migration, done int, total int)) error {
+	pending, err := s.PendingMigrations()
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			for _, migration := range pending {
+				if err := runMigration(tx, migration, progress); err != nil {
+					return err
+				}
+			}
+
+			return errDryRun
+		})
+		if err != errDryRun {
+			return err
+		}
+
+		return nil
+	}
+
+	for _, migration := range pending {
+		if err := s.db.Update(func(tx *bolt.Tx) error {
+			return runMigration(tx, migration, progress)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runMigration(tx *bolt.Tx, migration *Migration, progress func(migration *Migration, done int, total int)) error {
+	if err := migration.Migrate(tx, func(done int, total int) {
+		progress(migration, done, total)
+	}); err != nil {
+		return err
+	}
+
+	return putSchemaVersion(tx, migration.Version)
+}
+
+func logMigrationProgress(migration *Migration, done int, total int) {
+	if done != total && done%1000 != 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"version":     migration.Version,
+		"description": migration.Description,
+		"done":        done,
+		"total":       total,
+	}).Info("migrating the database")
+}
+
+// The migrations do not call the code connecting the blocks, which changes
+// along with the schema: each migration works with a copy of the code it needs,
+// as it was at its version, and writes the records in the shape of its
+// version. A copied helper is named after the version it was copied at, and is
+// shared by the later migrations needing it unchanged. Only the decoding of the
+// scripts and the serialization of the blocks, which are fixed by the ensicoin
+// protocol, are shared with the live code. A migration must not be changed
+// once released.
+
+// migrateHeightKeys rewrites the keys of heightToBlockBucket from their
+// former decimal string encoding to the big-endian one.
+func migrateHeightKeys(tx *bolt.Tx, progress func(int, int)) error {
+	migrated := make(map[uint32][]byte)
+
+	if err := tx.Bucket(heightToBlockBucket).ForEach(func(k, v []byte) error {
+		height, err := strconv.ParseUint(string(k), 10, 32)
+		if err != nil {
+			return err
+		}
+
+		migrated[uint32(height)] = append([]byte(nil), v...)
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.DeleteBucket(heightToBlockBucket); err != nil {
+		return err
+	}
+
+	heightToBlock, err := tx.CreateBucket(heightToBlockBucket)
+	if err != nil {
+		return err
+	}
+
+	var heights []uint32
+	for height := range migrated {
+		heights = append(heights, height)
+	}
+
+	sort.Slice(heights, func(i, j int) bool {
+		return heights[i] < heights[j]
+	})
+
+	for done, height := range heights {
+		key := make([]byte, 4)
+		binary.BigEndian.PutUint32(key, height)
+
+		if err := heightToBlock.Put(key, migrated[height]); err != nil {
+			return err
+		}
+
+		progress(done+1, len(heights))
+	}
+
+	return nil
+}
+
+// mainChainHashes returns the hashes of the main chain blocks, in height
+// order.
+func mainChainHashes(tx *bolt.Tx) ([]string, error) {
+	var hashes []string
+
+	if err := tx.Bucket(heightToBlockBucket).ForEach(func(k, v []byte) error {
+		hashes = append(hashes, string(v))
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return hashes, nil
+}
+
+// updateJsonObject sets fields of the JSON object stored under the key, leaving
+// the other fields as they are.
+func updateJsonObject(bucket *bolt.Bucket, key string, fields map[string]interface{}) error {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(bucket.Get([]byte(key)), &object); err != nil {
+		return err
+	}
+
+	for name, value := range fields {
+		valueBytes, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		object[name] = valueBytes
+	}
+
+	objectBytes, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put([]byte(key), objectBytes)
+}
+
+// findBlockV2 returns a stored block.
+func findBlockV2(tx *bolt.Tx, hash string) (*Block, error) {
+	blockBytes := tx.Bucket(blocksBucket).Get([]byte(hash))
+	if blockBytes == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	var block *Block
+
+	return block, json.Unmarshal(blockBytes, &block)
+}
+
+// findTxV2 returns a stored tx, the coinbase txs being those without inputs.
+func findTxV2(tx *bolt.Tx, hash string) (*Tx, error) {
+	txBytes := tx.Bucket(txsBucket).Get([]byte(hash))
+	if txBytes == nil {
+		return nil, ErrTxNotFound
+	}
+
+	var blockTx *Tx
+	if err := json.Unmarshal(txBytes, &blockTx); err != nil {
+		return nil, err
+	}
+
+	blockTx.Coinbase = len(blockTx.Inputs) == 0
+
+	return blockTx, nil
+}
+
+// findBlockTxsV2 returns the txs of a stored block, in their order in the
+// block.
+func findBlockTxsV2(tx *bolt.Tx, blockHash string) ([]*Tx, error) {
+	txHashesBytes := tx.Bucket(blockToTxsBucket).Get([]byte(blockHash))
+	if txHashesBytes == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	var txHashes []string
+	if err := json.Unmarshal(txHashesBytes, &txHashes); err != nil {
+		return nil, err
+	}
+
+	var txs []*Tx
+
+	for _, hash := range txHashes {
+		blockTx, err := findTxV2(tx, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		txs = append(txs, blockTx)
+	}
+
+	return txs, nil
+}
+
+// findSpentOutputV2 returns the output an outpoint refers to, or nil if it is
+// unknown.
+func findSpentOutputV2(tx *bolt.Tx, outpoint *Outpoint) (*TxOutput, error) {
+	previousTx, err := findTxV2(tx, outpoint.Hash)
+	if err == ErrTxNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if int(outpoint.Index) >= len(previousTx.Outputs) {
+		return nil, nil
+	}
+
+	return previousTx.Outputs[outpoint.Index], nil
+}
+
+// isPushV2 reports whether the opcode pushes the bytes following it.
+func isPushV2(opcode script.Opcode) bool {
+	if opcode == script.OP_TRUE || opcode == script.OP_DUP || opcode == script.OP_EQUAL {
+		return false
+	}
+
+	return opcode >= script.OP_PUSHDATA_MIN && opcode <= script.OP_PUSHDATA_MAX
+}
+
+// parseScriptV2 splits a script into its instructions, failing if it ends in
+// the middle of a push.
+func parseScriptV2(scriptBytes []byte) ([]*script.Instruction, error) {
+	var instructions []*script.Instruction
+
+	for i := 0; i < len(scriptBytes); i++ {
+		instruction := &script.Instruction{
+			Opcode: script.Opcode(scriptBytes[i]),
+		}
+
+		if isPushV2(instruction.Opcode) {
+			length := int(instruction.Opcode)
+			if i+length >= len(scriptBytes) {
+				return instructions, script.ErrTruncatedPush
+			}
+
+			instruction.Data = scriptBytes[i+1 : i+1+length]
+			i += length
+		}
+
+		instructions = append(instructions, instruction)
+	}
+
+	return instructions, nil
+}
+
+// extractPubKeyHashV2 returns the pubkey hash paid to by a pay-to-pubkey-hash
+// script.
+func extractPubKeyHashV2(scriptBytes []byte) ([]byte, bool) {
+	instructions, err := parseScriptV2(scriptBytes)
+	if err != nil || len(instructions) != 6 {
+		return nil, false
+	}
+
+	if instructions[0].Opcode != script.OP_DUP ||
+		instructions[1].Opcode != script.OP_HASH160 ||
+		len(instructions[2].Data) != 20 ||
+		instructions[3].Opcode != script.OP_EQUAL ||
+		instructions[4].Opcode != script.OP_VERIFY ||
+		instructions[5].Opcode != script.OP_CHECKSIG {
+		return nil, false
+	}
+
+	return instructions[2].Data, true
+}
+
+// scriptToAddressV2 derives the address an output script pays to: its pubkey
+// hash for a pay-to-pubkey-hash script, the sha256 of the script otherwise.
+func scriptToAddressV2(scriptHex string) string {
+	scriptBytes, err := hex.DecodeString(scriptHex)
+	if err != nil {
+		return ""
+	}
+
+	if pubKeyHash, ok := extractPubKeyHashV2(scriptBytes); ok {
+		return hex.EncodeToString(pubKeyHash)
+	}
+
+	hash := sha256.Sum256(scriptBytes)
+
+	return hex.EncodeToString(hash[:])
+}
+
+// rebuildIndexes indexes again the txs of the main chain blocks, for the
+// databases synchronized before these indexes existed.
+func rebuildIndexes(tx *bolt.Tx, progress func(int, int)) error {
+	for _, bucket := range [][]byte{txToBlockBucket, spentOutputsBucket, addressesBucket, addressEntriesBucket} {
+		if err := tx.DeleteBucket(bucket); err != nil {
+			return err
+		}
+
+		if _, err := tx.CreateBucket(bucket); err != nil {
+			return err
+		}
+	}
+
+	hashes, err := mainChainHashes(tx)
+	if err != nil {
+		return err
+	}
+
+	for done, hash := range hashes {
+		block, err := findBlockV2(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		txs, err := findBlockTxsV2(tx, hash)
+		if err == ErrBlockNotFound {
+			log.WithField("hash", hash).Warn("skipping a block without txs")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for index, blockTx := range txs {
+			locationBytes, err := json.Marshal(&TxLocation{
+				BlockHash: hash,
+				Index:     index,
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Bucket(txToBlockBucket).Put([]byte(blockTx.Hash), locationBytes); err != nil {
+				return err
+			}
+
+			for inputIndex, input := range blockTx.Inputs {
+				spentByBytes, err := json.Marshal(&SpentBy{
+					TxHash:     blockTx.Hash,
+					InputIndex: inputIndex,
+				})
+				if err != nil {
+					return err
+				}
+
+				key := fmt.Sprintf("%s:%d", input.PreviousOutput.Hash, input.PreviousOutput.Index)
+
+				if err := tx.Bucket(spentOutputsBucket).Put([]byte(key), spentByBytes); err != nil {
+					return err
+				}
+			}
+
+			if err := indexAddressEntriesV2(tx, block, blockTx, index); err != nil {
+				return err
+			}
+		}
+
+		progress(done+1, len(hashes))
+	}
+
+	return nil
+}
+
+// indexAddressEntriesV2 records the credits and debits of a tx in the address
+// histories, and adds them to the address totals.
+func indexAddressEntriesV2(tx *bolt.Tx, block *Block, blockTx *Tx, txIndex int) error {
+	entries := make(map[string][]*AddressEntry)
+
+	for index, input := range blockTx.Inputs {
+		previousTxBytes := tx.Bucket(txsBucket).Get([]byte(input.PreviousOutput.Hash))
+		if previousTxBytes == nil {
+			continue
+		}
+
+		var previousTx *Tx
+		if err := json.Unmarshal(previousTxBytes, &previousTx); err != nil {
+			return err
+		}
+
+		if int(input.PreviousOutput.Index) >= len(previousTx.Outputs) {
+			continue
+		}
+
+		spentOutput := previousTx.Outputs[input.PreviousOutput.Index]
+		address := scriptToAddressV2(spentOutput.Script)
+
+		entries[address] = append(entries[address], &AddressEntry{
+			Type:  AddressEntryDebit,
+			Index: index,
+			Value: spentOutput.Value,
+		})
+	}
+
+	for index, output := range blockTx.Outputs {
+		address := scriptToAddressV2(output.Script)
+
+		entries[address] = append(entries[address], &AddressEntry{
+			Type:  AddressEntryCredit,
+			Index: index,
+			Value: output.Value,
+		})
+	}
+
+	for address, addressEntries := range entries {
+		addr := &Address{
+			Address: address,
+		}
+
+		if addrBytes := tx.Bucket(addressesBucket).Get([]byte(address)); addrBytes != nil {
+			if err := json.Unmarshal(addrBytes, addr); err != nil {
+				return err
+			}
+		}
+
+		addr.TxCount++
+
+		for _, entry := range addressEntries {
+			entry.TxHash = blockTx.Hash
+			entry.BlockHash = block.Hash
+			entry.Height = block.Height
+			entry.Timestamp = block.Timestamp
+
+			if entry.Type == AddressEntryCredit {
+				addr.TotalReceived += entry.Value
+			} else {
+				addr.TotalSent += entry.Value
+			}
+
+			entryBytes, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+
+			// The entries are ordered by height, tx index, debits before
+			// credits, then entry index.
+			key := make([]byte, len(address)+1+4+4+1+4)
+			copy(key, address)
+			key[len(address)] = ':'
+			binary.BigEndian.PutUint32(key[len(address)+1:], entry.Height)
+			binary.BigEndian.PutUint32(key[len(address)+5:], uint32(txIndex))
+			if entry.Type == AddressEntryCredit {
+				key[len(address)+9] = 1
+			}
+			binary.BigEndian.PutUint32(key[len(address)+10:], uint32(entry.Index))
+
+			if err := tx.Bucket(addressEntriesBucket).Put(key, entryBytes); err != nil {
+				return err
+			}
+		}
+
+		addr.Balance = addr.TotalReceived - addr.TotalSent
+
+		addrBytes, err := json.Marshal(addr)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Bucket(addressesBucket).Put([]byte(address), addrBytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// chainStatsV3 are the chain statistics of the version 3.
+type chainStatsV3 struct {
+	TotalTxs     uint64 `json:"total_txs"`
+	TotalOutputs uint64 `json:"total_outputs"`
+	Supply       uint64 `json:"supply"`
+}
+
+// computeChainStats aggregates the txs of the main chain blocks, for the
+// databases synchronized before the chain statistics existed.
+func computeChainStats(tx *bolt.Tx, progress func(int, int)) error {
+	hashes, err := mainChainHashes(tx)
+	if err != nil {
+		return err
+	}
+
+	stats := &chainStatsV3{}
+
+	for done, hash := range hashes {
+		txs, err := findBlockTxsV2(tx, hash)
+		if err == ErrBlockNotFound {
+			log.WithField("hash", hash).Warn("skipping a block without txs")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, blockTx := range txs {
+			stats.TotalTxs++
+			stats.TotalOutputs += uint64(len(blockTx.Outputs))
+
+			if len(blockTx.Inputs) == 0 {
+				for _, output := range blockTx.Outputs {
+					stats.Supply += output.Value
+				}
+			}
+		}
+
+		progress(done+1, len(hashes))
+	}
+
+	statsBytes, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(statsBucket).Put(chainStatsKey, statsBytes)
+}
+
+// txMessageV4 and blockMessageV4 convert a tx and a block to their network
+// messages.
+func txMessageV4(tx *Tx) *network.TxMessage {
+	msg := &network.TxMessage{
+		Version: tx.Version,
+		Flags:   tx.Flags,
+	}
+
+	for _, input := range tx.Inputs {
+		hash, _ := utils.StringToHash(input.PreviousOutput.Hash)
+		scriptBytes, _ := hex.DecodeString(input.Script)
+
+		msg.Inputs = append(msg.Inputs, &network.TxIn{
+			PreviousOutput: &network.Outpoint{
+				Hash:  *hash,
+				Index: input.PreviousOutput.Index,
+			},
+			Script: scriptBytes,
+		})
+	}
+
+	for _, output := range tx.Outputs {
+		scriptBytes, _ := hex.DecodeString(output.Script)
+
+		msg.Outputs = append(msg.Outputs, &network.TxOut{
+			Value:  output.Value,
+			Script: scriptBytes,
+		})
+	}
+
+	return msg
+}
+
+func blockMessageV4(block *Block, txs []*Tx) *network.BlockMessage {
+	prevBlock, _ := utils.StringToHash(block.PrevBlock)
+	merkleRoot, _ := utils.StringToHash(block.MerkleRoot)
+	target, _ := utils.StringToHash(block.Target)
+
+	msg := &network.BlockMessage{
+		Header: &network.BlockHeader{
+			Version:        block.Version,
+			Flags:          block.Flags,
+			HashPrevBlock:  prevBlock,
+			HashMerkleRoot: merkleRoot,
+			Timestamp:      time.Unix(int64(block.Timestamp), 0),
+			Height:         block.Height,
+			Target:         new(big.Int).SetBytes(target.Bytes()),
+		},
+	}
+
+	for _, tx := range txs {
+		msg.Txs = append(msg.Txs, txMessageV4(tx))
+	}
+
+	return msg
+}
+
+// txSizeV4 and blockSizeV4 return the sizes in bytes of a tx and a block as
+// serialized on the network.
+func txSizeV4(tx *Tx) int {
+	buf := bytes.NewBuffer(nil)
+	_ = txMessageV4(tx).Encode(buf)
+
+	return buf.Len()
+}
+
+func blockSizeV4(block *Block, txs []*Tx) int {
+	buf := bytes.NewBuffer(nil)
+	_ = blockMessageV4(block, txs).Encode(buf)
+
+	return buf.Len()
+}
+
+// blockStatsV4 and txStatsV4 are the block and tx stats of the version 4.
+type blockStatsV4 struct {
+	Size      int    `json:"size"`
+	TxCount   int    `json:"tx_count"`
+	TotalFees uint64 `json:"total_fees"`
+	Reward    uint64 `json:"reward"`
+}
+
+type txStatsV4 struct {
+	Size        int     `json:"size"`
+	InputValue  uint64  `json:"input_value"`
+	OutputValue uint64  `json:"output_value"`
+	Fee         uint64  `json:"fee"`
+	FeeRate     float64 `json:"fee_rate"`
+}
+
+// computeStatsV4 computes the stats of a block and of its txs. findOutput
+// returns the output spent by an input, or nil if it is unknown.
+func computeStatsV4(block *Block, txs []*Tx, findOutput func(*Outpoint) (*TxOutput, error)) (*blockStatsV4, []*txStatsV4, error) {
+	blockTxs := make(map[string]*Tx)
+	for _, blockTx := range txs {
+		blockTxs[blockTx.Hash] = blockTx
+	}
+
+	blockStats := &blockStatsV4{
+		Size:    blockSizeV4(block, txs),
+		TxCount: len(txs),
+	}
+
+	var txsStats []*txStatsV4
+
+	for _, blockTx := range txs {
+		txStats := &txStatsV4{
+			Size: txSizeV4(blockTx),
+		}
+
+		for _, input := range blockTx.Inputs {
+			var spentOutput *TxOutput
+
+			if previousTx, ok := blockTxs[input.PreviousOutput.Hash]; ok {
+				if int(input.PreviousOutput.Index) < len(previousTx.Outputs) {
+					spentOutput = previousTx.Outputs[input.PreviousOutput.Index]
+				}
+			} else {
+				var err error
+
+				spentOutput, err = findOutput(input.PreviousOutput)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+
+			if spentOutput != nil {
+				txStats.InputValue += spentOutput.Value
+			}
+		}
+
+		for _, output := range blockTx.Outputs {
+			txStats.OutputValue += output.Value
+		}
+
+		if txStats.InputValue > txStats.OutputValue {
+			txStats.Fee = txStats.InputValue - txStats.OutputValue
+		}
+
+		if txStats.Size > 0 {
+			txStats.FeeRate = float64(txStats.Fee) / float64(txStats.Size)
+		}
+
+		blockStats.TotalFees += txStats.Fee
+		if len(blockTx.Inputs) == 0 {
+			blockStats.Reward += txStats.OutputValue
+		}
+
+		txsStats = append(txsStats, txStats)
+	}
+
+	return blockStats, txsStats, nil
+}
+
+// computeBlockStats computes the stats of every stored block, orphaned ones
+// included, along with the stats of their txs.
+func computeBlockStats(tx *bolt.Tx, progress func(int, int)) error {
+	var hashes []string
+
+	if err := tx.Bucket(blocksBucket).ForEach(func(k, v []byte) error {
+		hashes = append(hashes, string(k))
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for done, hash := range hashes {
+		block, err := findBlockV2(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		txs, err := findBlockTxsV2(tx, hash)
+		if err == ErrBlockNotFound {
+			log.WithField("hash", hash).Warn("skipping a block without txs")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		blockStats, txsStats, err := computeStatsV4(block, txs, func(outpoint *Outpoint) (*TxOutput, error) {
+			return findSpentOutputV2(tx, outpoint)
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := updateJsonObject(tx.Bucket(blocksBucket), hash, map[string]interface{}{
+			"stats": blockStats,
+		}); err != nil {
+			return err
+		}
+
+		for i, blockTx := range txs {
+			if err := updateJsonObject(tx.Bucket(txsBucket), blockTx.Hash, map[string]interface{}{
+				"stats": txsStats[i],
+			}); err != nil {
+				return err
+			}
+		}
+
+		progress(done+1, len(hashes))
+	}
+
+	return nil
+}
+
+// classifyV5 returns the type of an output script.
+func classifyV5(scriptBytes []byte) string {
+	if _, ok := extractPubKeyHashV2(scriptBytes); ok {
+		return script.TypePubKeyHash
+	}
+
+	instructions, err := parseScriptV2(scriptBytes)
+	if err != nil || len(instructions) == 0 {
+		return script.TypeNonStandard
+	}
+
+	if len(instructions) == 2 && len(instructions[0].Data) == 33 && instructions[1].Opcode == script.OP_CHECKSIG {
+		return script.TypePubKey
+	}
+
+	// A script made of pushes and ending with OP_FALSE only carries data.
+	last := len(instructions) - 1
+
+	for _, instruction := range instructions[:last] {
+		if !isPushV2(instruction.Opcode) {
+			return script.TypeNonStandard
+		}
+	}
+
+	if instructions[last].Opcode == script.OP_FALSE {
+		return script.TypeData
+	}
+
+	return script.TypeNonStandard
+}
+
+// payoutV5 is the payout of the version 5.
+type payoutV5 struct {
+	Script  string `json:"script"`
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Tag     string `json:"tag,omitempty"`
+}
+
+// findPayoutV5 decodes the coinbase tx of a block: the reward is paid to the
+// largest output not carrying data, and the tag is made of the printable data
+// carried by the other outputs. It returns nil if the block has no coinbase
+// tx.
+func findPayoutV5(txs []*Tx) *payoutV5 {
+	if len(txs) == 0 || len(txs[0].Inputs) != 0 {
+		return nil
+	}
+
+	var payoutScript string
+	var payoutValue uint64
+	var tags []string
+
+	for _, output := range txs[0].Outputs {
+		scriptBytes, _ := hex.DecodeString(output.Script)
+
+		if classifyV5(scriptBytes) != script.TypeData {
+			if payoutScript == "" || output.Value > payoutValue {
+				payoutScript = output.Script
+				payoutValue = output.Value
+			}
+
+			continue
+		}
+
+		instructions, _ := script.Parse(scriptBytes)
+		for _, instruction := range instructions {
+			printable := len(instruction.Data) > 0
+			for _, b := range instruction.Data {
+				if b < 0x20 || b > 0x7e {
+					printable = false
+				}
+			}
+
+			if printable {
+				tags = append(tags, string(instruction.Data))
+			}
+		}
+	}
+
+	payout := &payoutV5{
+		Tag: strings.Join(tags, " "),
+	}
+
+	if payoutScript != "" {
+		scriptBytes, _ := hex.DecodeString(payoutScript)
+
+		payout.Script = payoutScript
+		payout.Address = scriptToAddressV2(payoutScript)
+		payout.Type = classifyV5(scriptBytes)
+	}
+
+	return payout
+}
+
+// computePayouts decodes the coinbase tx of every stored block.
+func computePayouts(tx *bolt.Tx, progress func(int, int)) error {
+	var hashes []string
+
+	if err := tx.Bucket(blocksBucket).ForEach(func(k, v []byte) error {
+		hashes = append(hashes, string(k))
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for done, hash := range hashes {
+		txs, err := findBlockTxsV2(tx, hash)
+		if err == ErrBlockNotFound {
+			log.WithField("hash", hash).Warn("skipping a block without txs")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if payout := findPayoutV5(txs); payout != nil {
+			if err := updateJsonObject(tx.Bucket(blocksBucket), hash, map[string]interface{}{
+				"payout": payout,
+			}); err != nil {
+				return err
+			}
+		}
+
+		progress(done+1, len(hashes))
+	}
+
+	return nil
+}
+
+// difficultyV6 returns how many times harder than the genesis block, of target
+// 0xf << 232, it is to find a block under the target.
+func difficultyV6(target *big.Int) float64 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	genesisTarget := new(big.Float).SetInt(new(big.Int).Lsh(big.NewInt(15), 232))
+
+	difficulty, _ := new(big.Float).Quo(genesisTarget, new(big.Float).SetInt(target)).Float64()
+
+	return difficulty
+}
+
+// workV6 returns the expected number of hashes needed to find a block under
+// the target: 2^256 / (target + 1).
+func workV6(target *big.Int) *big.Int {
+	return new(big.Int).Quo(new(big.Int).Lsh(big.NewInt(1), 256), new(big.Int).Add(target, big.NewInt(1)))
+}
+
+// chainWorkV6 computes the difficulty and the chain work of blocks given in
+// height order, the chain work of a block whose parent is not among them
+// being its own work.
+type chainWorkV6 struct {
+	work map[string]*big.Int
+}
+
+// add returns the difficulty and the hex encoded chain work of the block.
+func (c *chainWorkV6) add(hash string, prevBlock string, target string) (float64, string) {
+	work := new(big.Int)
+	difficulty := float64(0)
+
+	if targetInt, ok := new(big.Int).SetString(target, 16); ok {
+		work = workV6(targetInt)
+		difficulty = difficultyV6(targetInt)
+	}
+
+	if parentWork, ok := c.work[prevBlock]; ok {
+		work.Add(work, parentWork)
+	}
+
+	c.work[hash] = work
+
+	return difficulty, work.Text(16)
+}
+
+// computeChainWork computes the difficulty and the chain work of every stored
+// block, the parents first.
+func computeChainWork(tx *bolt.Tx, progress func(int, int)) error {
+	var blocks []*Block
+
+	if err := tx.Bucket(blocksBucket).ForEach(func(k, v []byte) error {
+		var block *Block
+		if err := json.Unmarshal(v, &block); err != nil {
+			return err
+		}
+
+		blocks = append(blocks, block)
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	sort.Slice(blocks, func(i, j int) bool {
+		return blocks[i].Height < blocks[j].Height
+	})
+
+	chainWork := &chainWorkV6{
+		work: make(map[string]*big.Int),
+	}
+
+	for done, block := range blocks {
+		// The blocks stored before the stats existed and skipped by the
+		// version 4 migration have none.
+		var stored struct {
+			Stats map[string]json.RawMessage `json:"stats"`
+		}
+		if err := json.Unmarshal(tx.Bucket(blocksBucket).Get([]byte(block.Hash)), &stored); err != nil {
+			return err
+		}
+
+		stats := stored.Stats
+		if stats == nil {
+			stats = make(map[string]json.RawMessage)
+		}
+
+		difficulty, work := chainWork.add(block.Hash, block.PrevBlock, block.Target)
+
+		difficultyBytes, err := json.Marshal(difficulty)
+		if err != nil {
+			return err
+		}
+
+		workBytes, err := json.Marshal(work)
+		if err != nil {
+			return err
+		}
+
+		stats["difficulty"] = difficultyBytes
+		stats["chain_work"] = workBytes
+
+		if err := updateJsonObject(tx.Bucket(blocksBucket), block.Hash, map[string]interface{}{
+			"stats": stats,
+		}); err != nil {
+			return err
+		}
+
+		progress(done+1, len(blocks))
+	}
+
+	return nil
+}
+
+// activityBucketV7 is the activity bucket of the version 7.
+type activityBucketV7 struct {
+	Interval        string `json:"interval"`
+	Start           uint64 `json:"start"`
+	Blocks          int    `json:"blocks"`
+	Txs             int    `json:"txs"`
+	OutputVolume    uint64 `json:"output_volume"`
+	TotalSize       int    `json:"total_size"`
+	TotalInterval   int64  `json:"total_interval"`
+	Intervals       int    `json:"intervals"`
+	ActiveAddresses int    `json:"active_addresses"`
+}
+
+// activityIntervalsV7 are the durations of the activity intervals of the
+// version 7.
+var activityIntervalsV7 = map[string]uint64{
+	"hour": 3600,
+	"day":  86400,
+}
+
+// addActivityV7 adds a main chain block to its buckets, and returns the
+// addresses credited or debited by the block. parent is nil if the parent of
+// the block is unknown. size is the size of the block. findOutput returns the
+// output spent by an input, or nil if it is unknown.
+func addActivityV7(bucket *activityBucketV7, block *Block, parent *Block, size int, txs []*Tx, findOutput func(*Outpoint) (*TxOutput, error)) (map[string]struct{}, error) {
+	addresses := make(map[string]struct{})
+
+	bucket.Blocks++
+	bucket.Txs += len(txs)
+	bucket.TotalSize += size
+
+	if parent != nil {
+		bucket.TotalInterval += int64(block.Timestamp) - int64(parent.Timestamp)
+		bucket.Intervals++
+	}
+
+	for _, blockTx := range txs {
+		for _, input := range blockTx.Inputs {
+			spentOutput, err := findOutput(input.PreviousOutput)
+			if err != nil {
+				return nil, err
+			}
+
+			if spentOutput != nil {
+				addresses[scriptToAddressV2(spentOutput.Script)] = struct{}{}
+			}
+		}
+
+		for _, output := range blockTx.Outputs {
+			bucket.OutputVolume += output.Value
+			addresses[scriptToAddressV2(output.Script)] = struct{}{}
+		}
+	}
+
+	return addresses, nil
+}
+
+// computeActivity aggregates the activity of the main chain blocks.
+func computeActivity(tx *bolt.Tx, progress func(int, int)) error {
+	hashes, err := mainChainHashes(tx)
+	if err != nil {
+		return err
+	}
+
+	var parent *Block
+
+	for done, hash := range hashes {
+		block, err := findBlockV2(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		if parent != nil && parent.Hash != block.PrevBlock {
+			parent = nil
+		}
+
+		txs, err := findBlockTxsV2(tx, hash)
+		if err == ErrBlockNotFound {
+			log.WithField("hash", hash).Warn("skipping a block without txs")
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		size := blockSizeV4(block, txs)
+		if block.Stats != nil {
+			size = block.Stats.Size
+		}
+
+		for interval, seconds := range activityIntervalsV7 {
+			start := block.Timestamp - block.Timestamp%seconds
+
+			key := make([]byte, len(interval)+8)
+			copy(key, interval)
+			binary.BigEndian.PutUint64(key[len(interval):], start)
+
+			bucket := &activityBucketV7{
+				Interval: interval,
+				Start:    start,
+			}
+
+			if bucketBytes := tx.Bucket(activityBucket).Get(key); bucketBytes != nil {
+				if err := json.Unmarshal(bucketBytes, bucket); err != nil {
+					return err
+				}
+			}
+
+			addresses, err := addActivityV7(bucket, block, parent, size, txs, func(outpoint *Outpoint) (*TxOutput, error) {
+				return findSpentOutputV2(tx, outpoint)
+			})
+			if err != nil {
+				return err
+			}
+
+			// The addresses are counted once per bucket, along with the
+			// number of blocks of the bucket they are active in.
+			for address := range addresses {
+				addressKey := append(append([]byte(nil), key...), address...)
+
+				count := uint32(0)
+				if countBytes := tx.Bucket(activityAddressesBucket).Get(addressKey); countBytes != nil {
+					count = binary.BigEndian.Uint32(countBytes)
+				}
+
+				if count == 0 {
+					bucket.ActiveAddresses++
+				}
+
+				countBytes := make([]byte, 4)
+				binary.BigEndian.PutUint32(countBytes, count+1)
+
+				if err := tx.Bucket(activityAddressesBucket).Put(addressKey, countBytes); err != nil {
+					return err
+				}
+			}
+
+			bucketBytes, err := json.Marshal(bucket)
+			if err != nil {
+				return err
+			}
+
+			if err := tx.Bucket(activityBucket).Put(key, bucketBytes); err != nil {
+				return err
+			}
+		}
+
+		parent = block
+
+		progress(done+1, len(hashes))
+	}
+
+	return nil
+}
+
+// computeBlockIntervals adds to the chain statistics the time elapsed between
+// the main chain blocks and their parent, and the difficulty of the best
+// block.
+func computeBlockIntervals(tx *bolt.Tx, progress func(int, int)) error {
+	hashes, err := mainChainHashes(tx)
+	if err != nil {
+		return err
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	totalInterval := int64(0)
+	intervals := uint64(0)
+
+	var parent *Block
+
+	for done, hash := range hashes {
+		block, err := findBlockV2(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		if parent != nil && parent.Hash == block.PrevBlock {
+			totalInterval += int64(block.Timestamp) - int64(parent.Timestamp)
+			intervals++
+		}
+
+		parent = block
+
+		progress(done+1, len(hashes))
+	}
+
+	// The last block of the main chain is the best block.
+	difficulty := float64(0)
+	if target, ok := new(big.Int).SetString(parent.Target, 16); ok {
+		difficulty = difficultyV6(target)
+	}
+
+	return updateJsonObject(tx.Bucket(statsBucket), string(chainStatsKey), map[string]interface{}{
+		"total_block_interval": totalInterval,
+		"block_intervals":      intervals,
+		"difficulty":           difficulty,
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultMinersWindow = 576
+	maxMinersWindow     = 10000
+)
+
+var ErrUnknownPoolTagsFormat = errors.New("the pool tags file must be a .json, .yaml or .yml file")
+
+// PoolTags map the payouts of the blocks to the names of their miners, either
+// by the hex-encoded payout script or by a marker found in the coinbase tag.
+type PoolTags struct {
+	PayoutScripts map[string]string `json:"payout_scripts" yaml:"payout_scripts"`
+	CoinbaseTags  map[string]string `json:"coinbase_tags" yaml:"coinbase_tags"`
+}
+
+// LoadPoolTags reads a JSON or YAML pool tags file, the format being chosen
+// from its extension.
+func LoadPoolTags(path string) (*PoolTags, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags PoolTags
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		err = json.Unmarshal(data, &tags)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tags)
+	default:
+		err = ErrUnknownPoolTagsFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tags, nil
+}
+
+// Identify returns the name of the miner of a payout, or an empty string if
+// it is unknown. The payout script takes precedence over the coinbase tag, and
+// the longest marker found in the tag wins.
+func (tags *PoolTags) Identify(payout *Payout) string {
+	if tags == nil || payout == nil {
+		return ""
+	}
+
+	if name, ok := tags.PayoutScripts[strings.ToLower(payout.Script)]; ok {
+		return name
+	}
+
+	var name, marker string
+
+	for candidate, candidateName := range tags.CoinbaseTags {
+		if candidate == "" || !strings.Contains(payout.Tag, candidate) {
+			continue
+		}
+
+		if len(candidate) > len(marker) || (len(candidate) == len(marker) && candidate < marker) {
+			name, marker = candidateName, candidate
+		}
+	}
+
+	return name
+}
+
+// MinerEntry counts the blocks found by a miner. Unidentified miners are
+// grouped by payout address.
+type MinerEntry struct {
+	Miner   string  `json:"miner,omitempty"`
+	Address string  `json:"address,omitempty"`
+	Blocks  int     `json:"blocks"`
+	Share   float64 `json:"share"`
+}
+
+// handleMiners ranks the miners of the last blocks of the main chain by the
+// number of blocks they found.
+func (a *Api) handleMiners(c *gin.Context) {
+	window, err := strconv.Atoi(c.DefaultQuery("blocks", strconv.Itoa(defaultMinersWindow)))
+	if err != nil || window < 1 || window > maxMinersWindow {
+		abortWithError(c, http.StatusBadRequest, "invalid_blocks", "blocks must be an integer between 1 and "+strconv.Itoa(maxMinersWindow))
+		return
+	}
+
+	miners := []*MinerEntry{}
+
+	bestBlock, err := a.findBestBlock()
+	if err == ErrBestBlockHashNotFound {
+		c.JSON(http.StatusOK, gin.H{
+			"miners": miners,
+			"blocks": 0,
+		})
+		return
+	}
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	fromHeight := uint32(0)
+	if bestBlock.Height >= uint32(window) {
+		fromHeight = bestBlock.Height - uint32(window) + 1
+	}
+
+	entries := make(map[MinerEntry]*MinerEntry)
+	total := 0
+
+	if err := a.storage.IterateBlocks(fromHeight, bestBlock.Height, false, func(block *Block) error {
+		entry := &MinerEntry{
+			Miner: a.poolTags.Identify(block.Payout),
+		}
+		if entry.Miner == "" && block.Payout != nil {
+			entry.Address = block.Payout.Address
+		}
+
+		if known, ok := entries[*entry]; ok {
+			entry = known
+		} else {
+			entries[*entry] = entry
+			miners = append(miners, entry)
+		}
+
+		entry.Blocks++
+		total++
+
+		return nil
+	}); err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	for _, entry := range miners {
+		entry.Share = float64(entry.Blocks) / float64(total)
+	}
+
+	sort.SliceStable(miners, func(i, j int) bool {
+		return miners[i].Blocks > miners[j].Blocks
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"miners":      miners,
+		"blocks":      total,
+		"from_height": fromHeight,
+		"to_height":   bestBlock.Height,
+	})
+}
+
+// identifyMiner names the miner of the block from its payout.
+func (a *Api) identifyMiner(block *Block) {
+	block.Miner = a.poolTags.Identify(block.Payout)
+}
+
+// identifyEventMiner returns the event with the miner of its block named, if
+// it is a block event. The event being shared by every subscriber, its block
+// summary is copied.
+func (a *Api) identifyEventMiner(event *Event) *Event {
+	summary, ok := event.Data.(*BlockSummary)
+	if !ok {
+		return event
+	}
+
+	identified := *summary
+	identified.Miner = a.poolTags.Identify(summary.Payout)
+
+	return &Event{
+		Type: event.Type,
+		Data: &identified,
+	}
+}
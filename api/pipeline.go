@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	// downloadWorkers is the number of blocks downloaded concurrently.
+	downloadWorkers = 8
+	// downloadWindow bounds how far the downloads can get ahead of the
+	// oldest block not connected yet.
+	downloadWindow = 64
+	// connectBatchSize is the number of blocks connected in a single storage
+	// transaction, after which the checkpoint is saved.
+	connectBatchSize = 100
+	// headersBatchSize is the number of discovered headers persisted at once.
+	headersBatchSize = 1000
+
+	progressInterval = 5 * time.Second
+)
+
+type downloadedBlock struct {
+	block *Block
+	txs   []*Tx
+	err   error
+}
+
+// discoverHeaders walks the chain back from bestBlockHash to the first block
+// of the main chain, the fork block, or to the genesis block. It returns the
+// headers of the blocks to connect, from the newest to the oldest.
+//
+// The node has no headers only RPC, so the blocks are downloaded entirely.
+// The headers are persisted along with their txs as they are discovered, so
+// that neither an interrupted synchronization nor the connection of the
+// blocks download them again while the initial synchronization of a long
+// chain does not hold the whole chain in memory. The ones of the branches
+// abandoned since are pruned.
+func (s *Synchronizer) discoverHeaders(bestBlockHash string, genesisBlockHash string) ([]*Block, string, error) {
+	var headers []*Block
+	var newHeaders []*Block
+	var newTxs [][]*Tx
+
+	forkBlockHash := ""
+	currentHash := bestBlockHash
+
+	for {
+		inMainChain, err := s.storage.IsInMainChain(currentHash)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if inMainChain {
+			forkBlockHash = currentHash
+			break
+		}
+
+		block, err := s.storage.FindHeader(currentHash)
+		if err == ErrBlockNotFound {
+			var txs []*Tx
+
+			block, txs, err = s.findBlock(currentHash)
+			if err != nil {
+				// The blocks downloaded so far are kept for the next
+				// attempt.
+				if err := s.storage.StoreHeaders(newHeaders, newTxs); err != nil {
+					log.WithError(err).Warn("discovered headers not stored")
+				}
+
+				return nil, "", err
+			}
+
+			newHeaders = append(newHeaders, block)
+			newTxs = append(newTxs, txs)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+
+		headers = append(headers, block)
+
+		if len(newHeaders) == headersBatchSize {
+			if err := s.storage.StoreHeaders(newHeaders, newTxs); err != nil {
+				return nil, "", err
+			}
+
+			newHeaders = nil
+			newTxs = nil
+		}
+
+		if len(headers)%1000 == 0 {
+			log.WithFields(log.Fields{
+				"height":  block.Height,
+				"headers": len(headers),
+			}).Info("discovering the header chain")
+		}
+
+		if currentHash == genesisBlockHash {
+			break
+		}
+
+		currentHash = block.PrevBlock
+	}
+
+	if err := s.storage.StoreHeaders(newHeaders, newTxs); err != nil {
+		return nil, "", err
+	}
+
+	// The headers stored while synchronizing to a branch the node has
+	// abandoned since will never be connected.
+	if err := s.storage.PruneHeaders(headers); err != nil {
+		return nil, "", err
+	}
+
+	return headers, forkBlockHash, nil
+}
+
+// connectBlocks reads the txs of the blocks with a pool of workers,
+// downloading those not stored along with the headers, and connects the
+// blocks from the oldest to the newest so that the outputs spent by a block
+// are already known when indexing it. The blocks are connected in batches,
+// each one saving a checkpoint to resume from. headers are ordered from the
+// newest to the oldest.
+func (s *Synchronizer) connectBlocks(headers []*Block) error {
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+
+	downloads := make([]chan *downloadedBlock, len(headers))
+	for i := range downloads {
+		downloads[i] = make(chan *downloadedBlock, 1)
+	}
+
+	window := make(chan struct{}, downloadWindow)
+	jobs := make(chan int)
+
+	go func() {
+		defer close(jobs)
+
+		for i := len(headers) - 1; i >= 0; i-- {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for w := 0; w < downloadWorkers; w++ {
+		go func() {
+			for i := range jobs {
+				txs, err := s.storage.FindHeaderTxs(headers[i].Hash)
+				if err != ErrBlockNotFound {
+					downloads[i] <- &downloadedBlock{block: headers[i], txs: txs, err: err}
+					continue
+				}
+
+				block, txs, err := s.findBlock(headers[i].Hash)
+				downloads[i] <- &downloadedBlock{block: block, txs: txs, err: err}
+			}
+		}()
+	}
+
+	publishTxs := len(headers) <= maxTxEventsBlocks
+
+	var batch []*Block
+	var batchTxs [][]*Tx
+
+	start := time.Now()
+	lastProgress := start
+
+	for i := len(headers) - 1; i >= 0; i-- {
+		var downloaded *downloadedBlock
+
+		select {
+		case downloaded = <-downloads[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// The slot is released as soon as the block is received, a batch
+		// being larger than the window.
+		<-window
+
+		if downloaded.err != nil {
+			return downloaded.err
+		}
+
+		batch = append(batch, downloaded.block)
+		batchTxs = append(batchTxs, downloaded.txs)
+
+		if len(batch) < connectBatchSize && i > 0 {
+			continue
+		}
+
+		if err := s.storage.ConnectBlocks(batch, batchTxs, &Checkpoint{
+			Height:     downloaded.block.Height,
+			Hash:       downloaded.block.Hash,
+			TargetHash: headers[0].Hash,
+		}); err != nil {
+			return err
+		}
+
+		for j, block := range batch {
+			s.mempool.RemoveTxs(batchTxs[j])
+
+			var txEvents []*TxEvent
+			if publishTxs {
+				var err error
+
+				txEvents, err = s.newTxEvents(block, batchTxs[j])
+				if err != nil {
+					return err
+				}
+			}
+
+			s.events.publishBlockConnected(block, txEvents)
+		}
+
+		batch = nil
+		batchTxs = nil
+
+		if time.Since(lastProgress) >= progressInterval || i == 0 {
+			lastProgress = time.Now()
+
+			connected := len(headers) - i
+			rate := float64(connected) / time.Since(start).Seconds()
+
+			fields := log.Fields{
+				"height":       downloaded.block.Height,
+				"connected":    connected,
+				"remaining":    i,
+				"blocksPerSec": fmt.Sprintf("%.1f", rate),
+			}
+			if rate > 0 {
+				fields["eta"] = time.Duration(float64(i) / rate * float64(time.Second)).Round(time.Second).String()
+			}
+
+			log.WithFields(fields).Info("connecting blocks")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package script
+
+// The types of output scripts.
+const (
+	TypePubKeyHash  = "p2pkh"
+	TypePubKey      = "p2pk"
+	TypeData        = "data"
+	TypeNonStandard = "nonstandard"
+)
+
+const (
+	pubKeyHashLength = 20
+	pubKeyLength     = 33
+)
+
+// ExtractPubKeyHash returns the pubkey hash paid to by a pay-to-pubkey-hash
+// script: OP_DUP OP_HASH160 <pubkey hash> OP_EQUAL OP_VERIFY OP_CHECKSIG.
+func ExtractPubKeyHash(script []byte) ([]byte, bool) {
+	instructions, err := Parse(script)
+	if err != nil || len(instructions) != 6 {
+		return nil, false
+	}
+
+	if instructions[0].Opcode != OP_DUP ||
+		instructions[1].Opcode != OP_HASH160 ||
+		len(instructions[2].Data) != pubKeyHashLength ||
+		instructions[3].Opcode != OP_EQUAL ||
+		instructions[4].Opcode != OP_VERIFY ||
+		instructions[5].Opcode != OP_CHECKSIG {
+		return nil, false
+	}
+
+	return instructions[2].Data, true
+}
+
+// ExtractPubKey returns the pubkey paid to by a pay-to-pubkey script:
+// <compressed pubkey> OP_CHECKSIG.
+func ExtractPubKey(script []byte) ([]byte, bool) {
+	instructions, err := Parse(script)
+	if err != nil || len(instructions) != 2 {
+		return nil, false
+	}
+
+	if len(instructions[0].Data) != pubKeyLength || instructions[1].Opcode != OP_CHECKSIG {
+		return nil, false
+	}
+
+	return instructions[0].Data, true
+}
+
+// isData reports whether the script only carries data and can never be
+// spent: the input script being run before it, a script made of pushes and
+// ending with OP_FALSE always leaves false on top of the stack.
+func isData(script []byte) bool {
+	instructions, err := Parse(script)
+	if err != nil || len(instructions) == 0 {
+		return false
+	}
+
+	last := len(instructions) - 1
+
+	for _, instruction := range instructions[:last] {
+		if !instruction.Opcode.IsPush() {
+			return false
+		}
+	}
+
+	return instructions[last].Opcode == OP_FALSE
+}
+
+// Classify returns the type of an output script.
+func Classify(script []byte) string {
+	if _, ok := ExtractPubKeyHash(script); ok {
+		return TypePubKeyHash
+	}
+
+	if _, ok := ExtractPubKey(script); ok {
+		return TypePubKey
+	}
+
+	if isData(script) {
+		return TypeData
+	}
+
+	return TypeNonStandard
+}
@@ -0,0 +1,94 @@
+package script
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+const (
+	testPubKeyHash = "11223344556677889900112233445566778899aa"
+	testPubKey     = "02" + "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     string
+		scriptType string
+	}{
+		{"p2pkh", "64a014" + testPubKeyHash + "788caa", TypePubKeyHash},
+		{"p2pkh with a short hash", "64a013" + testPubKeyHash[2:] + "788caa", TypeNonStandard},
+		{"p2pkh without checksig", "64a014" + testPubKeyHash + "788c", TypeNonStandard},
+		{"p2pkh with a trailing opcode", "64a014" + testPubKeyHash + "788caa00", TypeNonStandard},
+		{"p2pk", "21" + testPubKey + "aa", TypePubKey},
+		{"p2pk with an uncompressed pubkey", "41" + testPubKey + strings.Repeat("00", 32) + "aa", TypeNonStandard},
+		{"p2pk without checksig", "21" + testPubKey, TypeNonStandard},
+		{"data", "00", TypeData},
+		{"data with pushes", "0268690301020300", TypeData},
+		{"data ending with a push", "00026869", TypeNonStandard},
+		{"data with an opcode", "02686950" + "00", TypeNonStandard},
+		{"truncated data", "056869", TypeNonStandard},
+		{"empty", "", TypeNonStandard},
+		{"true", "50", TypeNonStandard},
+	}
+
+	for _, test := range tests {
+		script, err := hex.DecodeString(test.script)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if scriptType := Classify(script); scriptType != test.scriptType {
+			t.Errorf("%s: Classify() = %q, want %q", test.name, scriptType, test.scriptType)
+		}
+	}
+}
+
+func TestExtractPubKeyHash(t *testing.T) {
+	tests := []struct {
+		script     string
+		pubKeyHash string
+		ok         bool
+	}{
+		{"64a014" + testPubKeyHash + "788caa", testPubKeyHash, true},
+		{"21" + testPubKey + "aa", "", false},
+		{"64a014" + testPubKeyHash[:38], "", false},
+	}
+
+	for _, test := range tests {
+		script, err := hex.DecodeString(test.script)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pubKeyHash, ok := ExtractPubKeyHash(script)
+		if ok != test.ok || hex.EncodeToString(pubKeyHash) != test.pubKeyHash {
+			t.Errorf("ExtractPubKeyHash(%s) = %x, %v, want %s, %v", test.script, pubKeyHash, ok, test.pubKeyHash, test.ok)
+		}
+	}
+}
+
+func TestExtractPubKey(t *testing.T) {
+	tests := []struct {
+		script string
+		pubKey string
+		ok     bool
+	}{
+		{"21" + testPubKey + "aa", testPubKey, true},
+		{"64a014" + testPubKeyHash + "788caa", "", false},
+		{"21" + testPubKey + "00", "", false},
+	}
+
+	for _, test := range tests {
+		script, err := hex.DecodeString(test.script)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pubKey, ok := ExtractPubKey(script)
+		if ok != test.ok || hex.EncodeToString(pubKey) != test.pubKey {
+			t.Errorf("ExtractPubKey(%s) = %x, %v, want %s, %v", test.script, pubKey, ok, test.pubKey, test.ok)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package script decodes ensicoin scripts into their assembly, and classifies
+// output scripts into the standard templates.
+package script
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type Opcode byte
+
+const (
+	OP_FALSE    Opcode = 0x00
+	OP_TRUE     Opcode = 0x50
+	OP_DUP      Opcode = 0x64
+	OP_EQUAL    Opcode = 0x78
+	OP_VERIFY   Opcode = 0x8c
+	OP_HASH160  Opcode = 0xa0
+	OP_CHECKSIG Opcode = 0xaa
+)
+
+// The opcodes from OP_PUSHDATA_MIN to OP_PUSHDATA_MAX not defined above push
+// the number of bytes they are equal to.
+const (
+	OP_PUSHDATA_MIN Opcode = 0x01
+	OP_PUSHDATA_MAX Opcode = 0x75
+)
+
+var opcodeNames = map[Opcode]string{
+	OP_FALSE:    "OP_FALSE",
+	OP_TRUE:     "OP_TRUE",
+	OP_DUP:      "OP_DUP",
+	OP_EQUAL:    "OP_EQUAL",
+	OP_VERIFY:   "OP_VERIFY",
+	OP_HASH160:  "OP_HASH160",
+	OP_CHECKSIG: "OP_CHECKSIG",
+}
+
+func (opcode Opcode) String() string {
+	if name, ok := opcodeNames[opcode]; ok {
+		return name
+	}
+
+	if opcode.IsPush() {
+		return fmt.Sprintf("OP_PUSHDATA_%d", opcode)
+	}
+
+	return fmt.Sprintf("OP_UNKNOWN_0x%02x", byte(opcode))
+}
+
+// IsPush reports whether the opcode pushes the bytes following it.
+func (opcode Opcode) IsPush() bool {
+	_, named := opcodeNames[opcode]
+
+	return !named && opcode >= OP_PUSHDATA_MIN && opcode <= OP_PUSHDATA_MAX
+}
+
+var ErrTruncatedPush = errors.New("script ends in the middle of a push")
+
+// Instruction is an opcode, along with the data it pushes if any.
+type Instruction struct {
+	Opcode Opcode
+	Data   []byte
+}
+
+func (instruction *Instruction) String() string {
+	if instruction.Opcode.IsPush() {
+		return hex.EncodeToString(instruction.Data)
+	}
+
+	return instruction.Opcode.String()
+}
+
+// Parse splits the script into its instructions. If the script ends in the
+// middle of a push, the instructions parsed so far are returned along with
+// ErrTruncatedPush.
+func Parse(script []byte) ([]*Instruction, error) {
+	var instructions []*Instruction
+
+	for i := 0; i < len(script); i++ {
+		instruction := &Instruction{
+			Opcode: Opcode(script[i]),
+		}
+
+		if instruction.Opcode.IsPush() {
+			length := int(instruction.Opcode)
+			if i+length >= len(script) {
+				return instructions, ErrTruncatedPush
+			}
+
+			instruction.Data = script[i+1 : i+1+length]
+			i += length
+		}
+
+		instructions = append(instructions, instruction)
+	}
+
+	return instructions, nil
+}
+
+// Disassemble returns the assembly of the script, pushed data being
+// hex-encoded. A truncated push is shown as [error].
+func Disassemble(script []byte) string {
+	instructions, err := Parse(script)
+
+	var parts []string
+	for _, instruction := range instructions {
+		parts = append(parts, instruction.String())
+	}
+
+	if err != nil {
+		parts = append(parts, "[error]")
+	}
+
+	return strings.Join(parts, " ")
+}
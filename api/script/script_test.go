@@ -0,0 +1,169 @@
+package script
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestOpcodeIsPush(t *testing.T) {
+	tests := []struct {
+		opcode Opcode
+		isPush bool
+	}{
+		{OP_FALSE, false},
+		{OP_PUSHDATA_MIN, true},
+		{0x14, true},
+		{0x4f, true},
+		{OP_TRUE, false},
+		{OP_DUP, false},
+		{OP_EQUAL, false},
+		{OP_PUSHDATA_MAX, true},
+		{0x76, false},
+		{OP_VERIFY, false},
+		{OP_HASH160, false},
+		{OP_CHECKSIG, false},
+		{0xff, false},
+	}
+
+	for _, test := range tests {
+		if isPush := test.opcode.IsPush(); isPush != test.isPush {
+			t.Errorf("Opcode(0x%02x).IsPush() = %v, want %v", byte(test.opcode), isPush, test.isPush)
+		}
+	}
+}
+
+func TestOpcodeString(t *testing.T) {
+	tests := []struct {
+		opcode Opcode
+		name   string
+	}{
+		{OP_FALSE, "OP_FALSE"},
+		{OP_TRUE, "OP_TRUE"},
+		{OP_CHECKSIG, "OP_CHECKSIG"},
+		{0x01, "OP_PUSHDATA_1"},
+		{0x14, "OP_PUSHDATA_20"},
+		{0x75, "OP_PUSHDATA_117"},
+		{0x76, "OP_UNKNOWN_0x76"},
+		{0xff, "OP_UNKNOWN_0xff"},
+	}
+
+	for _, test := range tests {
+		if name := test.opcode.String(); name != test.name {
+			t.Errorf("Opcode(0x%02x).String() = %q, want %q", byte(test.opcode), name, test.name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		script       string
+		instructions []*Instruction
+		err          error
+	}{
+		{
+			name:   "empty",
+			script: "",
+		},
+		{
+			name:   "opcodes",
+			script: "0050aa",
+			instructions: []*Instruction{
+				{Opcode: OP_FALSE},
+				{Opcode: OP_TRUE},
+				{Opcode: OP_CHECKSIG},
+			},
+		},
+		{
+			name:   "single byte push",
+			script: "01ff",
+			instructions: []*Instruction{
+				{Opcode: 0x01, Data: []byte{0xff}},
+			},
+		},
+		{
+			name:   "pushes between opcodes",
+			script: "6402abcd7803010203",
+			instructions: []*Instruction{
+				{Opcode: OP_DUP},
+				{Opcode: 0x02, Data: []byte{0xab, 0xcd}},
+				{Opcode: OP_EQUAL},
+				{Opcode: 0x03, Data: []byte{0x01, 0x02, 0x03}},
+			},
+		},
+		{
+			name:   "unknown opcode",
+			script: "7600",
+			instructions: []*Instruction{
+				{Opcode: 0x76},
+				{Opcode: OP_FALSE},
+			},
+		},
+		{
+			name:   "truncated push",
+			script: "6403abcd",
+			instructions: []*Instruction{
+				{Opcode: OP_DUP},
+			},
+			err: ErrTruncatedPush,
+		},
+		{
+			name:   "push without data",
+			script: "0001",
+			instructions: []*Instruction{
+				{Opcode: OP_FALSE},
+			},
+			err: ErrTruncatedPush,
+		},
+	}
+
+	for _, test := range tests {
+		instructions, err := Parse(mustDecodeHex(t, test.script))
+		if err != test.err {
+			t.Errorf("%s: Parse() error = %v, want %v", test.name, err, test.err)
+		}
+
+		if len(instructions) != len(test.instructions) {
+			t.Errorf("%s: Parse() returned %d instructions, want %d", test.name, len(instructions), len(test.instructions))
+			continue
+		}
+
+		for i, instruction := range instructions {
+			want := test.instructions[i]
+			if instruction.Opcode != want.Opcode || !bytes.Equal(instruction.Data, want.Data) {
+				t.Errorf("%s: instruction %d = %v %x, want %v %x", test.name, i, instruction.Opcode, instruction.Data, want.Opcode, want.Data)
+			}
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		script string
+		asm    string
+	}{
+		{"", ""},
+		{"00", "OP_FALSE"},
+		{"64a014" + "11223344556677889900112233445566778899aa" + "788caa", "OP_DUP OP_HASH160 11223344556677889900112233445566778899aa OP_EQUAL OP_VERIFY OP_CHECKSIG"},
+		{"02686900", "6869 OP_FALSE"},
+		{"76", "OP_UNKNOWN_0x76"},
+		{"5003abcd", "OP_TRUE [error]"},
+		{"05", "[error]"},
+	}
+
+	for _, test := range tests {
+		if asm := Disassemble(mustDecodeHex(t, test.script)); asm != test.asm {
+			t.Errorf("Disassemble(%s) = %q, want %q", test.script, asm, test.asm)
+		}
+	}
+}
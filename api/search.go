@@ -0,0 +1,160 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	SearchResultBlock   = "block"
+	SearchResultTx      = "tx"
+	SearchResultAddress = "address"
+)
+
+const (
+	// minSearchPrefixLength is the length under which a query is not matched
+	// against the prefixes of the hashes and addresses.
+	minSearchPrefixLength = 4
+	// maxSearchCandidates bounds the number of candidates of each type.
+	maxSearchCandidates = 10
+)
+
+type SearchResult struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+}
+
+// findExactMatch looks the query up as a height, a block hash, a tx hash and
+// an address, returning nil if nothing matches.
+func (a *Api) findExactMatch(query string) (*SearchResult, error) {
+	// Heights are not zero padded, such queries are hash prefixes.
+	if height, err := strconv.ParseUint(query, 10, 32); err == nil && (query == "0" || query[0] != '0') {
+		block, err := a.storage.FindBlockByHeight(uint32(height))
+		if err != nil && err != ErrBlockNotFound {
+			return nil, err
+		}
+
+		if block != nil {
+			return &SearchResult{Type: SearchResultBlock, Id: block.Hash}, nil
+		}
+	}
+
+	if len(query) == 64 {
+		block, err := a.storage.FindBlockByHash(query)
+		if err != nil && err != ErrBlockNotFound {
+			return nil, err
+		}
+
+		if block != nil {
+			return &SearchResult{Type: SearchResultBlock, Id: block.Hash}, nil
+		}
+
+		tx, err := a.storage.FindTxByHash(query)
+		if err != nil && err != ErrTxNotFound {
+			return nil, err
+		}
+
+		if tx != nil {
+			return &SearchResult{Type: SearchResultTx, Id: tx.Hash}, nil
+		}
+	}
+
+	addr, err := a.storage.FindAddress(query)
+	if err != nil && err != ErrAddressNotFound {
+		return nil, err
+	}
+
+	if addr != nil {
+		return &SearchResult{Type: SearchResultAddress, Id: addr.Address}, nil
+	}
+
+	return nil, nil
+}
+
+func isHex(s string) bool {
+	return strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// findCandidates returns the blocks, txs and addresses whose hash or address
+// starts with the query.
+func (a *Api) findCandidates(query string) ([]*SearchResult, error) {
+	candidates := []*SearchResult{}
+
+	finders := []struct {
+		resultType string
+		find       func(string, int) ([]string, error)
+	}{
+		{SearchResultBlock, a.storage.FindBlockHashesByPrefix},
+		{SearchResultTx, a.storage.FindTxHashesByPrefix},
+		{SearchResultAddress, a.storage.FindAddressesByPrefix},
+	}
+
+	for _, finder := range finders {
+		ids, err := finder.find(query, maxSearchCandidates)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids {
+			candidates = append(candidates, &SearchResult{
+				Type: finder.resultType,
+				Id:   id,
+			})
+		}
+	}
+
+	return candidates, nil
+}
+
+// handleSearch classifies the query as a height, a block hash, a tx hash or an
+// address. An exact match, or a single candidate, is returned as result, from
+// which the client redirects; otherwise the candidates whose hash starts with
+// the query are listed.
+func (a *Api) handleSearch(c *gin.Context) {
+	query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if query == "" {
+		abortWithError(c, http.StatusBadRequest, "invalid_query", "q must not be empty")
+		return
+	}
+
+	result, err := a.findExactMatch(query)
+	if err != nil {
+		abortWithInternalError(c, err)
+		return
+	}
+
+	if result != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"result": result,
+		})
+		return
+	}
+
+	candidates := []*SearchResult{}
+
+	if isHex(query) && len(query) >= minSearchPrefixLength {
+		candidates, err = a.findCandidates(query)
+		if err != nil {
+			abortWithInternalError(c, err)
+			return
+		}
+	}
+
+	if len(candidates) == 0 {
+		abortWithError(c, http.StatusNotFound, "no_match", "nothing matches "+query)
+		return
+	}
+
+	if len(candidates) == 1 {
+		c.JSON(http.StatusOK, gin.H{
+			"result": candidates[0],
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"candidates": candidates,
+	})
+}
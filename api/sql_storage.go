@@ -0,0 +1,1660 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	_ "github.com/mattn/go-sqlite3"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// sqlMigrations are the statements upgrading the SQL schema, one list of
+// statements per schema version. New versions must be appended.
+var sqlMigrations = [][]string{
+	{
+		`CREATE TABLE stats (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
+		`CREATE TABLE blocks (
+			hash TEXT PRIMARY KEY,
+			version INTEGER NOT NULL,
+			flags TEXT NOT NULL,
+			prev_block TEXT NOT NULL,
+			merkle_root TEXT NOT NULL,
+			timestamp INTEGER NOT NULL,
+			height INTEGER NOT NULL,
+			target TEXT NOT NULL,
+			in_main_chain INTEGER NOT NULL DEFAULT 0,
+			orphaned_at INTEGER
+		)`,
+		`CREATE INDEX blocks_height ON blocks (height)`,
+		`CREATE UNIQUE INDEX blocks_main_chain_height ON blocks (height) WHERE in_main_chain = 1`,
+		`CREATE TABLE txs (
+			hash TEXT PRIMARY KEY,
+			version INTEGER NOT NULL,
+			flags TEXT NOT NULL
+		)`,
+		`CREATE TABLE block_txs (
+			block_hash TEXT NOT NULL REFERENCES blocks (hash),
+			tx_hash TEXT NOT NULL REFERENCES txs (hash),
+			position INTEGER NOT NULL,
+			PRIMARY KEY (block_hash, position)
+		)`,
+		`CREATE INDEX block_txs_tx_hash ON block_txs (tx_hash)`,
+		`CREATE TABLE tx_inputs (
+			tx_hash TEXT NOT NULL REFERENCES txs (hash),
+			input_index INTEGER NOT NULL,
+			previous_hash TEXT NOT NULL,
+			previous_index INTEGER NOT NULL,
+			script TEXT NOT NULL,
+			PRIMARY KEY (tx_hash, input_index)
+		)`,
+		`CREATE INDEX tx_inputs_previous_output ON tx_inputs (previous_hash, previous_index)`,
+		`CREATE TABLE tx_outputs (
+			tx_hash TEXT NOT NULL REFERENCES txs (hash),
+			output_index INTEGER NOT NULL,
+			value INTEGER NOT NULL,
+			script TEXT NOT NULL,
+			address TEXT NOT NULL,
+			PRIMARY KEY (tx_hash, output_index)
+		)`,
+		`CREATE INDEX tx_outputs_address ON tx_outputs (address)`,
+		// The views only cover the main chain, and are meant for ad-hoc
+		// queries as well as for the explorer itself.
+		`CREATE VIEW main_chain_txs AS
+			SELECT bt.tx_hash, bt.position, b.hash AS block_hash, b.height, b.timestamp
+			FROM block_txs bt
+			JOIN blocks b ON b.hash = bt.block_hash
+			WHERE b.in_main_chain = 1`,
+		`CREATE VIEW address_entries AS
+			SELECT o.address, 'credit' AS type, 1 AS type_order, o.tx_hash, o.output_index AS entry_index, o.value,
+				t.block_hash, t.height, t.timestamp, t.position
+			FROM tx_outputs o
+			JOIN main_chain_txs t ON t.tx_hash = o.tx_hash
+			UNION ALL
+			SELECT o.address, 'debit' AS type, 0 AS type_order, i.tx_hash, i.input_index AS entry_index, o.value,
+				t.block_hash, t.height, t.timestamp, t.position
+			FROM tx_inputs i
+			JOIN tx_outputs o ON o.tx_hash = i.previous_hash AND o.output_index = i.previous_index
+			JOIN main_chain_txs t ON t.tx_hash = i.tx_hash`,
+	},
+	{
+		`CREATE TABLE headers (
+			hash TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+	},
+	{
+		`INSERT OR REPLACE INTO stats (key, value) SELECT 'chainStats',
+			'{"total_txs":' || (SELECT COUNT(*) FROM main_chain_txs) ||
+			',"total_outputs":' || (SELECT COUNT(*) FROM tx_outputs o JOIN main_chain_txs t ON t.tx_hash = o.tx_hash) ||
+			',"supply":' || (SELECT COALESCE(SUM(o.value), 0) FROM tx_outputs o JOIN main_chain_txs t ON t.tx_hash = o.tx_hash
+				WHERE NOT EXISTS (SELECT 1 FROM tx_inputs i WHERE i.tx_hash = o.tx_hash)) || '}'`,
+	},
+	{
+		`ALTER TABLE blocks ADD COLUMN size INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE blocks ADD COLUMN tx_count INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE blocks ADD COLUMN total_fees INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE blocks ADD COLUMN reward INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE txs ADD COLUMN size INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE txs ADD COLUMN input_value INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE txs ADD COLUMN output_value INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE txs ADD COLUMN fee INTEGER NOT NULL DEFAULT 0`,
+	},
+	{
+		`ALTER TABLE blocks ADD COLUMN payout_script TEXT`,
+		`ALTER TABLE blocks ADD COLUMN payout_tag TEXT`,
+	},
+	{
+		`ALTER TABLE blocks ADD COLUMN difficulty REAL NOT NULL DEFAULT 0`,
+		`ALTER TABLE blocks ADD COLUMN chain_work TEXT NOT NULL DEFAULT ''`,
+	},
+	{
+		`CREATE TABLE activity_buckets (
+			bucket_interval TEXT NOT NULL,
+			start INTEGER NOT NULL,
+			blocks INTEGER NOT NULL,
+			txs INTEGER NOT NULL,
+			output_volume INTEGER NOT NULL,
+			total_size INTEGER NOT NULL,
+			total_interval INTEGER NOT NULL,
+			intervals INTEGER NOT NULL,
+			active_addresses INTEGER NOT NULL,
+			PRIMARY KEY (bucket_interval, start)
+		)`,
+		// blocks is the number of blocks of the bucket the address is
+		// active in.
+		`CREATE TABLE activity_addresses (
+			bucket_interval TEXT NOT NULL,
+			start INTEGER NOT NULL,
+			address TEXT NOT NULL,
+			blocks INTEGER NOT NULL,
+			PRIMARY KEY (bucket_interval, start, address)
+		)`,
+	},
+	// The chain statistics are a JSON object, completed by
+	// computeSqlBlockIntervals.
+	{},
+	// addresses are running totals, so that an address is not aggregated
+	// from its whole history every time it is viewed.
+	{
+		`CREATE TABLE addresses (
+			address TEXT PRIMARY KEY,
+			total_received INTEGER NOT NULL,
+			total_sent INTEGER NOT NULL,
+			tx_count INTEGER NOT NULL
+		)`,
+		`INSERT INTO addresses (address, total_received, total_sent, tx_count)
+			SELECT address,
+				COALESCE(SUM(CASE WHEN type = 'credit' THEN value ELSE 0 END), 0),
+				COALESCE(SUM(CASE WHEN type = 'debit' THEN value ELSE 0 END), 0),
+				COUNT(DISTINCT tx_hash)
+			FROM address_entries GROUP BY address`,
+	},
+	// header_txs are the txs downloaded along with the headers, so that the
+	// blocks are not downloaded again to be connected.
+	{
+		`CREATE TABLE header_txs (
+			hash TEXT PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+	},
+}
+
+// sqlMigrationFuncs complete the statements of the schema versions whose data
+// can not be computed in SQL. They are run after the statements.
+var sqlMigrationFuncs = map[int]func(tx *sql.Tx) error{
+	4: computeSqlBlockStats,
+	5: computeSqlPayouts,
+	6: computeSqlChainWork,
+	7: computeSqlActivity,
+	8: computeSqlBlockIntervals,
+}
+
+// SqlStorage stores the chain in an embedded SQLite database, with one table
+// per entity so that it can be queried with plain SQL.
+type SqlStorage struct {
+	path string
+	db   *sql.DB
+}
+
+func NewSqlStorage(path string) *SqlStorage {
+	return &SqlStorage{
+		path: path,
+	}
+}
+
+func (s *SqlStorage) Open() error {
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	return s.Migrate(false)
+}
+
+// open opens the database without migrating it.
+func (s *SqlStorage) open() (err error) {
+	s.db, err = sql.Open("sqlite3", s.path+"?_foreign_keys=1")
+	if err != nil {
+		return err
+	}
+
+	// SQLite does not support concurrent writers, using a single connection
+	// serializes the accesses.
+	s.db.SetMaxOpenConns(1)
+
+	_, err = s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`)
+
+	return err
+}
+
+func (s *SqlStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SqlStorage) SchemaVersion() (int, error) {
+	var version int
+
+	err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+
+	return version, err
+}
+
+// Migrate applies the pending schema versions, each one in its own
+// transaction. With dryRun set, every version is applied in a single
+// transaction, which is then rolled back so that the database is left
+// untouched.
+func (s *SqlStorage) Migrate(dryRun bool) error {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		for ; version < len(sqlMigrations); version++ {
+			if err := runSqlMigration(tx, version+1); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		return tx.Rollback()
+	}
+
+	for ; version < len(sqlMigrations); version++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if err := runSqlMigration(tx, version+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runSqlMigration upgrades the schema from version-1 to version.
+func runSqlMigration(tx *sql.Tx, version int) error {
+	for _, statement := range sqlMigrations[version-1] {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	if migrate, ok := sqlMigrationFuncs[version]; ok {
+		if err := migrate(tx); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, version)
+
+	return err
+}
+
+func (s *SqlStorage) FindBestBlockHash() (string, error) {
+	var hash string
+
+	err := s.db.QueryRow(`SELECT value FROM stats WHERE key = 'bestBlockHash'`).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", ErrBestBlockHashNotFound
+	}
+
+	return hash, err
+}
+
+func (s *SqlStorage) ConnectBlocks(blocks []*Block, txs [][]*Tx, checkpoint *Checkpoint) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for i, block := range blocks {
+		if err := connectSqlBlock(tx, block, txs[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := putSqlCheckpoint(tx, checkpoint); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func connectSqlBlock(tx *sql.Tx, block *Block, txs []*Tx) error {
+	parent, err := scanSqlBlock(tx.QueryRow(`SELECT `+sqlBlockColumns+` FROM blocks WHERE hash = ?`, block.PrevBlock))
+	if err != nil && err != ErrBlockNotFound {
+		return err
+	}
+
+	if err := ComputeStats(block, parent, txs, func(outpoint *Outpoint) (*TxOutput, error) {
+		return findSqlSpentOutput(tx, outpoint)
+	}); err != nil {
+		return err
+	}
+
+	block.Payout = FindPayout(txs)
+
+	flags, err := json.Marshal(block.Flags)
+	if err != nil {
+		return err
+	}
+
+	payoutScript, payoutTag := sqlPayoutColumns(block.Payout)
+
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO blocks (hash, version, flags, prev_block, merkle_root, timestamp, height, target,
+			size, tx_count, total_fees, reward, difficulty, chain_work, payout_script, payout_tag)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		block.Hash, block.Version, string(flags), block.PrevBlock, block.MerkleRoot, block.Timestamp, block.Height, block.Target,
+		block.Stats.Size, block.Stats.TxCount, block.Stats.TotalFees, block.Stats.Reward, block.Stats.Difficulty, block.Stats.ChainWork,
+		payoutScript, payoutTag); err != nil {
+		return err
+	}
+
+	for position, btx := range txs {
+		if err := storeSqlTx(tx, btx); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO block_txs (block_hash, tx_hash, position) VALUES (?, ?, ?)`,
+			block.Hash, btx.Hash, position); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE blocks SET in_main_chain = 1, orphaned_at = NULL WHERE hash = ?`, block.Hash); err != nil {
+		return err
+	}
+
+	if err := updateSqlAddresses(tx, txs, 1); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM headers WHERE hash = ?`, block.Hash); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM header_txs WHERE hash = ?`, block.Hash); err != nil {
+		return err
+	}
+
+	if err := updateSqlChainStats(tx, func(stats *ChainStats) {
+		stats.ConnectTxs(txs)
+		stats.ConnectBlock(block, parent)
+	}); err != nil {
+		return err
+	}
+
+	if err := updateSqlActivity(tx, block, parent, txs, 1); err != nil {
+		return err
+	}
+
+	return putBestBlockHash(tx, block.Hash)
+}
+
+func storeSqlTx(tx *sql.Tx, btx *Tx) error {
+	flags, err := json.Marshal(btx.Flags)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`INSERT OR IGNORE INTO txs (hash, version, flags, size, input_value, output_value, fee) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		btx.Hash, btx.Version, string(flags), btx.Stats.Size, btx.Stats.InputValue, btx.Stats.OutputValue, btx.Stats.Fee)
+	if err != nil {
+		return err
+	}
+
+	// A tx already stored was stored along with its inputs and outputs.
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+		return err
+	}
+
+	for index, input := range btx.Inputs {
+		if _, err := tx.Exec(`INSERT INTO tx_inputs (tx_hash, input_index, previous_hash, previous_index, script) VALUES (?, ?, ?, ?, ?)`,
+			btx.Hash, index, input.PreviousOutput.Hash, input.PreviousOutput.Index, input.Script); err != nil {
+			return err
+		}
+	}
+
+	for index, output := range btx.Outputs {
+		if _, err := tx.Exec(`INSERT INTO tx_outputs (tx_hash, output_index, value, script, address) VALUES (?, ?, ?, ?, ?)`,
+			btx.Hash, index, output.Value, output.Script, ScriptToAddress(output.Script)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findSqlSpentOutput returns the output an outpoint refers to, or nil if it is
+// unknown.
+func findSqlSpentOutput(q sqlQuerier, outpoint *Outpoint) (*TxOutput, error) {
+	output := &TxOutput{}
+
+	err := q.QueryRow(`SELECT value, script FROM tx_outputs WHERE tx_hash = ? AND output_index = ?`,
+		outpoint.Hash, outpoint.Index).Scan(&output.Value, &output.Script)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return output, nil
+}
+
+// computeSqlBlockStats computes the stats of every stored block, orphaned ones
+// included, along with the stats of their txs.
+func computeSqlBlockStats(tx *sql.Tx) error {
+	// The columns are listed rather than taken from sqlBlockColumns, which
+	// may name columns added by later versions.
+	rows, err := tx.Query(`SELECT hash, version, flags, prev_block, merkle_root, timestamp, height, target FROM blocks`)
+	if err != nil {
+		return err
+	}
+
+	var blocks []*Block
+
+	for rows.Next() {
+		var block Block
+		var flags string
+
+		if err := rows.Scan(&block.Hash, &block.Version, &flags, &block.PrevBlock, &block.MerkleRoot, &block.Timestamp, &block.Height, &block.Target); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(flags), &block.Flags); err != nil {
+			rows.Close()
+			return err
+		}
+
+		blocks = append(blocks, &block)
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, block := range blocks {
+		txs, err := findSqlMigrationTxs(tx, block.Hash)
+		if err != nil {
+			return err
+		}
+
+		blockStats, txsStats, err := computeStatsV4(block, txs, func(outpoint *Outpoint) (*TxOutput, error) {
+			return findSqlSpentOutput(tx, outpoint)
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`UPDATE blocks SET size = ?, tx_count = ?, total_fees = ?, reward = ? WHERE hash = ?`,
+			blockStats.Size, blockStats.TxCount, blockStats.TotalFees, blockStats.Reward, block.Hash); err != nil {
+			return err
+		}
+
+		for i, btx := range txs {
+			if _, err := tx.Exec(`UPDATE txs SET size = ?, input_value = ?, output_value = ?, fee = ? WHERE hash = ?`,
+				txsStats[i].Size, txsStats[i].InputValue, txsStats[i].OutputValue, txsStats[i].Fee, btx.Hash); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// findSqlMigrationTxs returns the txs of a block for the migrations, reading
+// only the columns of the version 1.
+func findSqlMigrationTxs(tx *sql.Tx, blockHash string) ([]*Tx, error) {
+	rows, err := tx.Query(`SELECT t.hash, t.version, t.flags FROM block_txs bt JOIN txs t ON t.hash = bt.tx_hash
+		WHERE bt.block_hash = ? ORDER BY bt.position`, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []*Tx
+
+	for rows.Next() {
+		btx := &Tx{}
+		var flags string
+
+		if err := rows.Scan(&btx.Hash, &btx.Version, &flags); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if err := json.Unmarshal([]byte(flags), &btx.Flags); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		txs = append(txs, btx)
+	}
+
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, btx := range txs {
+		inputRows, err := tx.Query(`SELECT previous_hash, previous_index, script FROM tx_inputs WHERE tx_hash = ? ORDER BY input_index`, btx.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		for inputRows.Next() {
+			input := &TxInput{
+				PreviousOutput: &Outpoint{},
+			}
+
+			if err := inputRows.Scan(&input.PreviousOutput.Hash, &input.PreviousOutput.Index, &input.Script); err != nil {
+				inputRows.Close()
+				return nil, err
+			}
+
+			btx.Inputs = append(btx.Inputs, input)
+		}
+
+		if err := inputRows.Close(); err != nil {
+			return nil, err
+		}
+
+		outputRows, err := tx.Query(`SELECT value, script FROM tx_outputs WHERE tx_hash = ? ORDER BY output_index`, btx.Hash)
+		if err != nil {
+			return nil, err
+		}
+
+		for outputRows.Next() {
+			output := &TxOutput{}
+
+			if err := outputRows.Scan(&output.Value, &output.Script); err != nil {
+				outputRows.Close()
+				return nil, err
+			}
+
+			btx.Outputs = append(btx.Outputs, output)
+		}
+
+		if err := outputRows.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return txs, nil
+}
+
+// sqlPayoutColumns returns the values of the payout_script and payout_tag
+// columns, NULL if there is no payout.
+func sqlPayoutColumns(payout *Payout) (sql.NullString, sql.NullString) {
+	if payout == nil {
+		return sql.NullString{}, sql.NullString{}
+	}
+
+	return sql.NullString{String: payout.Script, Valid: true}, sql.NullString{String: payout.Tag, Valid: true}
+}
+
+// computeSqlPayouts decodes the coinbase tx of every stored block.
+func computeSqlPayouts(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT hash FROM blocks`)
+	if err != nil {
+		return err
+	}
+
+	var hashes []string
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return err
+		}
+
+		hashes = append(hashes, hash)
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		txs, err := findSqlMigrationTxs(tx, hash)
+		if err != nil {
+			return err
+		}
+
+		var payoutScript, payoutTag sql.NullString
+
+		if payout := findPayoutV5(txs); payout != nil {
+			payoutScript = sql.NullString{String: payout.Script, Valid: true}
+			payoutTag = sql.NullString{String: payout.Tag, Valid: true}
+		}
+
+		if _, err := tx.Exec(`UPDATE blocks SET payout_script = ?, payout_tag = ? WHERE hash = ?`, payoutScript, payoutTag, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// computeSqlChainWork computes the difficulty and the chain work of every
+// stored block, the parents first.
+func computeSqlChainWork(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT hash, prev_block, target FROM blocks ORDER BY height`)
+	if err != nil {
+		return err
+	}
+
+	var blocks []*Block
+
+	for rows.Next() {
+		block := &Block{}
+
+		if err := rows.Scan(&block.Hash, &block.PrevBlock, &block.Target); err != nil {
+			rows.Close()
+			return err
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	chainWork := &chainWorkV6{
+		work: make(map[string]*big.Int),
+	}
+
+	for _, block := range blocks {
+		difficulty, work := chainWork.add(block.Hash, block.PrevBlock, block.Target)
+
+		if _, err := tx.Exec(`UPDATE blocks SET difficulty = ?, chain_work = ? WHERE hash = ?`, difficulty, work, block.Hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func putBestBlockHash(tx *sql.Tx, hash string) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO stats (key, value) VALUES ('bestBlockHash', ?)`, hash)
+
+	return err
+}
+
+func findSqlChainStats(tx *sql.Tx) (*ChainStats, error) {
+	stats := &ChainStats{}
+
+	var statsJson string
+
+	err := tx.QueryRow(`SELECT value FROM stats WHERE key = 'chainStats'`).Scan(&statsJson)
+	if err == sql.ErrNoRows {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(statsJson), stats); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+func updateSqlChainStats(tx *sql.Tx, update func(*ChainStats)) error {
+	stats, err := findSqlChainStats(tx)
+	if err != nil {
+		return err
+	}
+
+	update(stats)
+
+	statsBytes, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO stats (key, value) VALUES ('chainStats', ?)`, string(statsBytes))
+
+	return err
+}
+
+// updateSqlActivity adds the activity of a connected block to its buckets, or
+// removes the activity of a disconnected one if sign is negative.
+func updateSqlActivity(tx *sql.Tx, block *Block, parent *Block, txs []*Tx, sign int) error {
+	activity, err := NewBlockActivity(block, parent, txs, func(outpoint *Outpoint) (*TxOutput, error) {
+		return findSqlSpentOutput(tx, outpoint)
+	})
+	if err != nil {
+		return err
+	}
+
+	for interval := range activityIntervals {
+		bucket := &ActivityBucket{
+			Interval: interval,
+			Start:    activityBucketStart(interval, activity.Timestamp),
+		}
+
+		err := tx.QueryRow(`SELECT blocks, txs, output_volume, total_size, total_interval, intervals, active_addresses FROM activity_buckets
+			WHERE bucket_interval = ? AND start = ?`, bucket.Interval, bucket.Start).Scan(&bucket.Blocks, &bucket.Txs, &bucket.OutputVolume,
+			&bucket.TotalSize, &bucket.TotalInterval, &bucket.Intervals, &bucket.ActiveAddresses)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		for address := range activity.Addresses {
+			var count int
+
+			err := tx.QueryRow(`SELECT blocks FROM activity_addresses WHERE bucket_interval = ? AND start = ? AND address = ?`,
+				bucket.Interval, bucket.Start, address).Scan(&count)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+
+			if sign > 0 {
+				count++
+
+				if count == 1 {
+					bucket.ActiveAddresses++
+				}
+			} else {
+				// An address that was never counted is left as is.
+				if count <= 0 {
+					continue
+				}
+
+				count--
+
+				if count == 0 {
+					bucket.ActiveAddresses--
+
+					if _, err := tx.Exec(`DELETE FROM activity_addresses WHERE bucket_interval = ? AND start = ? AND address = ?`,
+						bucket.Interval, bucket.Start, address); err != nil {
+						return err
+					}
+
+					continue
+				}
+			}
+
+			if _, err := tx.Exec(`INSERT OR REPLACE INTO activity_addresses (bucket_interval, start, address, blocks) VALUES (?, ?, ?, ?)`,
+				bucket.Interval, bucket.Start, address, count); err != nil {
+				return err
+			}
+		}
+
+		bucket.Apply(activity, sign)
+
+		if bucket.Blocks == 0 {
+			_, err = tx.Exec(`DELETE FROM activity_buckets WHERE bucket_interval = ? AND start = ?`, bucket.Interval, bucket.Start)
+		} else {
+			_, err = tx.Exec(`INSERT OR REPLACE INTO activity_buckets
+				(bucket_interval, start, blocks, txs, output_volume, total_size, total_interval, intervals, active_addresses)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, bucket.Interval, bucket.Start, bucket.Blocks, bucket.Txs, bucket.OutputVolume,
+				bucket.TotalSize, bucket.TotalInterval, bucket.Intervals, bucket.ActiveAddresses)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// computeSqlActivity aggregates the activity of the main chain blocks.
+func computeSqlActivity(tx *sql.Tx) error {
+	// The columns are listed rather than taken from sqlBlockColumns, which
+	// may name columns added by later versions.
+	rows, err := tx.Query(`SELECT hash, prev_block, timestamp, size FROM blocks WHERE in_main_chain = 1 ORDER BY height`)
+	if err != nil {
+		return err
+	}
+
+	type activityBlock struct {
+		block *Block
+		size  int
+	}
+
+	var blocks []*activityBlock
+
+	for rows.Next() {
+		block := &activityBlock{
+			block: &Block{},
+		}
+
+		if err := rows.Scan(&block.block.Hash, &block.block.PrevBlock, &block.block.Timestamp, &block.size); err != nil {
+			rows.Close()
+			return err
+		}
+
+		blocks = append(blocks, block)
+	}
+
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	var parent *Block
+
+	for _, block := range blocks {
+		if parent != nil && parent.Hash != block.block.PrevBlock {
+			parent = nil
+		}
+
+		txs, err := findSqlMigrationTxs(tx, block.block.Hash)
+		if err != nil {
+			return err
+		}
+
+		for interval, seconds := range activityIntervalsV7 {
+			bucket := &activityBucketV7{
+				Interval: interval,
+				Start:    block.block.Timestamp - block.block.Timestamp%seconds,
+			}
+
+			err := tx.QueryRow(`SELECT blocks, txs, output_volume, total_size, total_interval, intervals, active_addresses FROM activity_buckets
+				WHERE bucket_interval = ? AND start = ?`, bucket.Interval, bucket.Start).Scan(&bucket.Blocks, &bucket.Txs, &bucket.OutputVolume,
+				&bucket.TotalSize, &bucket.TotalInterval, &bucket.Intervals, &bucket.ActiveAddresses)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+
+			addresses, err := addActivityV7(bucket, block.block, parent, block.size, txs, func(outpoint *Outpoint) (*TxOutput, error) {
+				return findSqlSpentOutput(tx, outpoint)
+			})
+			if err != nil {
+				return err
+			}
+
+			for address := range addresses {
+				result, err := tx.Exec(`UPDATE activity_addresses SET blocks = blocks + 1 WHERE bucket_interval = ? AND start = ? AND address = ?`,
+					bucket.Interval, bucket.Start, address)
+				if err != nil {
+					return err
+				}
+
+				if updated, err := result.RowsAffected(); err != nil {
+					return err
+				} else if updated > 0 {
+					continue
+				}
+
+				bucket.ActiveAddresses++
+
+				if _, err := tx.Exec(`INSERT INTO activity_addresses (bucket_interval, start, address, blocks) VALUES (?, ?, ?, 1)`,
+					bucket.Interval, bucket.Start, address); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.Exec(`INSERT OR REPLACE INTO activity_buckets
+				(bucket_interval, start, blocks, txs, output_volume, total_size, total_interval, intervals, active_addresses)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, bucket.Interval, bucket.Start, bucket.Blocks, bucket.Txs, bucket.OutputVolume,
+				bucket.TotalSize, bucket.TotalInterval, bucket.Intervals, bucket.ActiveAddresses); err != nil {
+				return err
+			}
+		}
+
+		parent = block.block
+	}
+
+	return nil
+}
+
+// computeSqlBlockIntervals adds to the chain statistics the time elapsed
+// between the main chain blocks and their parent, and the difficulty of the
+// best block.
+func computeSqlBlockIntervals(tx *sql.Tx) error {
+	var statsJson string
+
+	err := tx.QueryRow(`SELECT value FROM stats WHERE key = 'chainStats'`).Scan(&statsJson)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stats map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(statsJson), &stats); err != nil {
+		return err
+	}
+
+	var totalInterval int64
+	var intervals uint64
+
+	if err := tx.QueryRow(`SELECT COALESCE(SUM(b.timestamp - p.timestamp), 0), COUNT(*)
+		FROM blocks b
+		JOIN blocks p ON p.hash = b.prev_block
+		WHERE b.in_main_chain = 1`).Scan(&totalInterval, &intervals); err != nil {
+		return err
+	}
+
+	difficulty := float64(0)
+
+	var target string
+
+	err = tx.QueryRow(`SELECT target FROM blocks WHERE in_main_chain = 1 ORDER BY height DESC LIMIT 1`).Scan(&target)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if targetInt, ok := new(big.Int).SetString(target, 16); ok {
+		difficulty = difficultyV6(targetInt)
+	}
+
+	for name, value := range map[string]interface{}{
+		"total_block_interval": totalInterval,
+		"block_intervals":      intervals,
+		"difficulty":           difficulty,
+	} {
+		valueBytes, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		stats[name] = valueBytes
+	}
+
+	statsBytes, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO stats (key, value) VALUES ('chainStats', ?)`, string(statsBytes))
+
+	return err
+}
+
+func (s *SqlStorage) FindActivityBuckets(interval string, from uint64, to uint64) ([]*ActivityBucket, error) {
+	rows, err := s.db.Query(`SELECT start, blocks, txs, output_volume, total_size, total_interval, intervals, active_addresses FROM activity_buckets
+		WHERE bucket_interval = ? AND start BETWEEN ? AND ?
+		ORDER BY start`, interval, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []*ActivityBucket{}
+
+	for rows.Next() {
+		bucket := &ActivityBucket{
+			Interval: interval,
+		}
+
+		if err := rows.Scan(&bucket.Start, &bucket.Blocks, &bucket.Txs, &bucket.OutputVolume, &bucket.TotalSize,
+			&bucket.TotalInterval, &bucket.Intervals, &bucket.ActiveAddresses); err != nil {
+			return nil, err
+		}
+
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, rows.Err()
+}
+
+func putSqlCheckpoint(tx *sql.Tx, checkpoint *Checkpoint) error {
+	checkpointBytes, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT OR REPLACE INTO stats (key, value) VALUES ('syncCheckpoint', ?)`, string(checkpointBytes))
+
+	return err
+}
+
+func (s *SqlStorage) DisconnectBlock(block *Block) error {
+	// The txs are read beforehand, the transaction holding the only
+	// connection.
+	txs, err := s.FindTxs(block.Hash)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE blocks SET in_main_chain = 0, orphaned_at = ? WHERE hash = ?`, time.Now().Unix(), block.Hash); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := updateSqlAddresses(tx, txs, -1); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	parent, err := scanSqlBlock(tx.QueryRow(`SELECT `+sqlBlockColumns+` FROM blocks WHERE hash = ?`, block.PrevBlock))
+	if err != nil && err != ErrBlockNotFound {
+		tx.Rollback()
+		return err
+	}
+
+	if err := updateSqlChainStats(tx, func(stats *ChainStats) {
+		stats.DisconnectTxs(txs)
+		stats.DisconnectBlock(block, parent)
+	}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := updateSqlActivity(tx, block, parent, txs, -1); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Without the genesis block, the chain is empty and has no best block.
+	if block.Height == 0 {
+		_, err = tx.Exec(`DELETE FROM stats WHERE key IN ('syncCheckpoint', 'bestBlockHash')`)
+	} else {
+		err = putSqlCheckpoint(tx, &Checkpoint{
+			Height:     block.Height - 1,
+			Hash:       block.PrevBlock,
+			TargetHash: block.PrevBlock,
+		})
+		if err == nil {
+			err = putBestBlockHash(tx, block.PrevBlock)
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqlStorage) FindCheckpoint() (*Checkpoint, error) {
+	var checkpointJson string
+
+	err := s.db.QueryRow(`SELECT value FROM stats WHERE key = 'syncCheckpoint'`).Scan(&checkpointJson)
+	if err == sql.ErrNoRows {
+		return nil, ErrCheckpointNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoint Checkpoint
+
+	if err := json.Unmarshal([]byte(checkpointJson), &checkpoint); err != nil {
+		return nil, err
+	}
+
+	return &checkpoint, nil
+}
+
+func (s *SqlStorage) FindChainStats() (*ChainStats, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findSqlChainStats(tx)
+}
+
+func (s *SqlStorage) StoreHeaders(headers []*Block, txs [][]*Tx) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for i, header := range headers {
+		headerBytes, err := json.Marshal(header)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO headers (hash, data) VALUES (?, ?)`, header.Hash, string(headerBytes)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		txsBytes, err := json.Marshal(txs[i])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO header_txs (hash, data) VALUES (?, ?)`, header.Hash, string(txsBytes)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqlStorage) PruneHeaders(keep []*Block) error {
+	keepHashes := make(map[string]struct{}, len(keep))
+	for _, header := range keep {
+		keepHashes[header.Hash] = struct{}{}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT hash FROM headers`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var pruned []string
+
+	for rows.Next() {
+		var hash string
+
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+
+		if _, ok := keepHashes[hash]; !ok {
+			pruned = append(pruned, hash)
+		}
+	}
+
+	if err := rows.Close(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, hash := range pruned {
+		if _, err := tx.Exec(`DELETE FROM headers WHERE hash = ?`, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM header_txs WHERE hash = ?`, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *SqlStorage) FindHeader(hash string) (*Block, error) {
+	var headerJson string
+
+	err := s.db.QueryRow(`SELECT data FROM headers WHERE hash = ?`, hash).Scan(&headerJson)
+	if err == sql.ErrNoRows {
+		return nil, ErrBlockNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var header Block
+
+	if err := json.Unmarshal([]byte(headerJson), &header); err != nil {
+		return nil, err
+	}
+
+	return &header, nil
+}
+
+func (s *SqlStorage) FindHeaderTxs(hash string) ([]*Tx, error) {
+	var txsJson string
+
+	err := s.db.QueryRow(`SELECT data FROM header_txs WHERE hash = ?`, hash).Scan(&txsJson)
+	if err == sql.ErrNoRows {
+		return nil, ErrBlockNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []*Tx
+
+	if err := json.Unmarshal([]byte(txsJson), &txs); err != nil {
+		return nil, err
+	}
+
+	return txs, nil
+}
+
+func (s *SqlStorage) HasBlock(hash string) (bool, error) {
+	var count int
+
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM blocks WHERE hash = ?`, hash).Scan(&count)
+
+	return count > 0, err
+}
+
+func (s *SqlStorage) IsInMainChain(hash string) (bool, error) {
+	var count int
+
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM blocks WHERE hash = ? AND in_main_chain = 1`, hash).Scan(&count)
+
+	return count > 0, err
+}
+
+func (s *SqlStorage) IsOrphanBlock(hash string) (bool, error) {
+	var count int
+
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM blocks WHERE hash = ? AND orphaned_at IS NOT NULL`, hash).Scan(&count)
+
+	return count > 0, err
+}
+
+const sqlBlockColumns = `hash, version, flags, prev_block, merkle_root, timestamp, height, target, size, tx_count, total_fees, reward,
+	difficulty, chain_work, payout_script, payout_tag`
+
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx.
+type sqlQuerier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func scanSqlBlock(row sqlScanner) (*Block, error) {
+	block := Block{
+		Stats: &BlockStats{},
+	}
+	var flags string
+	var payoutScript, payoutTag sql.NullString
+
+	if err := row.Scan(&block.Hash, &block.Version, &flags, &block.PrevBlock, &block.MerkleRoot, &block.Timestamp, &block.Height, &block.Target,
+		&block.Stats.Size, &block.Stats.TxCount, &block.Stats.TotalFees, &block.Stats.Reward, &block.Stats.Difficulty, &block.Stats.ChainWork,
+		&payoutScript, &payoutTag); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrBlockNotFound
+		}
+
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(flags), &block.Flags); err != nil {
+		return nil, err
+	}
+
+	if payoutScript.Valid {
+		block.Payout = newPayout(payoutScript.String, payoutTag.String)
+	}
+
+	return &block, nil
+}
+
+func (s *SqlStorage) FindBlockByHash(hash string) (*Block, error) {
+	return scanSqlBlock(s.db.QueryRow(`SELECT `+sqlBlockColumns+` FROM blocks WHERE hash = ?`, hash))
+}
+
+func (s *SqlStorage) FindBlockByHeight(height uint32) (*Block, error) {
+	return scanSqlBlock(s.db.QueryRow(`SELECT `+sqlBlockColumns+` FROM blocks WHERE height = ? AND in_main_chain = 1`, height))
+}
+
+func (s *SqlStorage) IterateBlocks(fromHeight uint32, toHeight uint32, reverse bool, fn func(*Block) error) error {
+	order := "ASC"
+	if reverse {
+		order = "DESC"
+	}
+
+	rows, err := s.db.Query(`SELECT `+sqlBlockColumns+` FROM blocks
+		WHERE in_main_chain = 1 AND height BETWEEN ? AND ?
+		ORDER BY height `+order, fromHeight, toHeight)
+	if err != nil {
+		return err
+	}
+
+	// The rows are streamed to fn, which does not use the store and thus
+	// does not need the connection they hold.
+	for rows.Next() {
+		block, err := scanSqlBlock(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		if err := fn(block); err != nil {
+			rows.Close()
+			return err
+		}
+	}
+
+	return rows.Close()
+}
+
+func (s *SqlStorage) FindBlocksByHeights(heights []uint32) (map[uint32]*Block, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	blocks := make(map[uint32]*Block)
+
+	for _, height := range heights {
+		block, err := scanSqlBlock(tx.QueryRow(`SELECT `+sqlBlockColumns+` FROM blocks WHERE height = ? AND in_main_chain = 1`, height))
+		if err == ErrBlockNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		blocks[height] = block
+	}
+
+	return blocks, nil
+}
+
+func (s *SqlStorage) FindTxs(blockHash string) ([]*Tx, error) {
+	exist, err := s.HasBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exist {
+		return nil, ErrBlockNotFound
+	}
+
+	return findSqlBlockTxs(s.db, blockHash)
+}
+
+func findSqlBlockTxs(q sqlQuerier, blockHash string) ([]*Tx, error) {
+	rows, err := q.Query(`SELECT tx_hash FROM block_txs WHERE block_hash = ? ORDER BY position`, blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	var txHashes []string
+
+	for rows.Next() {
+		var txHash string
+		if err := rows.Scan(&txHash); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		txHashes = append(txHashes, txHash)
+	}
+
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	var txs []*Tx
+
+	for _, txHash := range txHashes {
+		tx, err := findSqlTx(q, txHash)
+		if err != nil {
+			return nil, err
+		}
+
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
+
+func (s *SqlStorage) FindTxByHash(hash string) (*Tx, error) {
+	return findSqlTx(s.db, hash)
+}
+
+func findSqlTx(q sqlQuerier, hash string) (*Tx, error) {
+	tx := &Tx{
+		Hash: hash,
+	}
+
+	var flags string
+	var size int
+	var inputValue, outputValue uint64
+
+	err := q.QueryRow(`SELECT version, flags, size, input_value, output_value FROM txs WHERE hash = ?`, hash).Scan(&tx.Version, &flags, &size, &inputValue, &outputValue)
+	if err == sql.ErrNoRows {
+		return nil, ErrTxNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(flags), &tx.Flags); err != nil {
+		return nil, err
+	}
+
+	tx.Stats = newTxStats(size, inputValue, outputValue)
+
+	inputRows, err := q.Query(`SELECT previous_hash, previous_index, script FROM tx_inputs WHERE tx_hash = ? ORDER BY input_index`, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	for inputRows.Next() {
+		input := &TxInput{
+			PreviousOutput: &Outpoint{},
+		}
+
+		if err := inputRows.Scan(&input.PreviousOutput.Hash, &input.PreviousOutput.Index, &input.Script); err != nil {
+			inputRows.Close()
+			return nil, err
+		}
+
+		tx.Inputs = append(tx.Inputs, input)
+	}
+
+	if err := inputRows.Close(); err != nil {
+		return nil, err
+	}
+
+	outputRows, err := q.Query(`SELECT value, script FROM tx_outputs WHERE tx_hash = ? ORDER BY output_index`, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	for outputRows.Next() {
+		output := &TxOutput{}
+
+		if err := outputRows.Scan(&output.Value, &output.Script); err != nil {
+			outputRows.Close()
+			return nil, err
+		}
+
+		tx.Outputs = append(tx.Outputs, output)
+	}
+
+	if err := outputRows.Close(); err != nil {
+		return nil, err
+	}
+
+	tx.Coinbase = isCoinbase(tx)
+
+	return tx, nil
+}
+
+func (s *SqlStorage) FindTxLocation(hash string) (*TxLocation, error) {
+	var location TxLocation
+
+	err := s.db.QueryRow(`SELECT block_hash, position FROM main_chain_txs WHERE tx_hash = ?`, hash).Scan(&location.BlockHash, &location.Index)
+	if err == sql.ErrNoRows {
+		return nil, ErrTxNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &location, nil
+}
+
+func (s *SqlStorage) FindSpentBy(outpoint *Outpoint) (*SpentBy, error) {
+	var spentBy SpentBy
+
+	err := s.db.QueryRow(`SELECT i.tx_hash, i.input_index FROM tx_inputs i
+		JOIN main_chain_txs t ON t.tx_hash = i.tx_hash
+		WHERE i.previous_hash = ? AND i.previous_index = ?`, outpoint.Hash, outpoint.Index).Scan(&spentBy.TxHash, &spentBy.InputIndex)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &spentBy, nil
+}
+
+// updateSqlAddresses adds the credits and debits of the txs of a connected
+// block to the totals of their addresses, or removes those of a disconnected
+// one if sign is negative.
+func updateSqlAddresses(tx *sql.Tx, txs []*Tx, sign int) error {
+	for _, btx := range txs {
+		received := make(map[string]uint64)
+		sent := make(map[string]uint64)
+
+		for _, input := range btx.Inputs {
+			spentOutput, err := findSqlSpentOutput(tx, input.PreviousOutput)
+			if err != nil {
+				return err
+			}
+
+			if spentOutput != nil {
+				sent[ScriptToAddress(spentOutput.Script)] += spentOutput.Value
+			}
+		}
+
+		for _, output := range btx.Outputs {
+			received[ScriptToAddress(output.Script)] += output.Value
+		}
+
+		addresses := make(map[string]struct{})
+		for address := range received {
+			addresses[address] = struct{}{}
+		}
+		for address := range sent {
+			addresses[address] = struct{}{}
+		}
+
+		for address := range addresses {
+			addr := &Address{
+				Address: address,
+			}
+
+			err := tx.QueryRow(`SELECT total_received, total_sent, tx_count FROM addresses WHERE address = ?`,
+				address).Scan(&addr.TotalReceived, &addr.TotalSent, &addr.TxCount)
+			if err == sql.ErrNoRows && sign < 0 {
+				continue
+			}
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+
+			if sign > 0 {
+				addr.TotalReceived += received[address]
+				addr.TotalSent += sent[address]
+				addr.TxCount++
+			} else {
+				addr.TotalReceived -= received[address]
+				addr.TotalSent -= sent[address]
+				addr.TxCount--
+			}
+
+			if addr.TxCount <= 0 {
+				_, err = tx.Exec(`DELETE FROM addresses WHERE address = ?`, address)
+			} else {
+				_, err = tx.Exec(`INSERT OR REPLACE INTO addresses (address, total_received, total_sent, tx_count) VALUES (?, ?, ?, ?)`,
+					address, addr.TotalReceived, addr.TotalSent, addr.TxCount)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) FindAddress(address string) (*Address, error) {
+	addr := &Address{
+		Address: address,
+	}
+
+	err := s.db.QueryRow(`SELECT total_received, total_sent, tx_count FROM addresses WHERE address = ?`,
+		address).Scan(&addr.TotalReceived, &addr.TotalSent, &addr.TxCount)
+	if err == sql.ErrNoRows {
+		return nil, ErrAddressNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	addr.Balance = addr.TotalReceived - addr.TotalSent
+
+	return addr, nil
+}
+
+func (s *SqlStorage) FindAddressEntries(address string, page int, limit int) ([]*AddressEntry, error) {
+	rows, err := s.db.Query(`SELECT type, tx_hash, entry_index, value, block_hash, height, timestamp FROM address_entries
+		WHERE address = ?
+		ORDER BY height DESC, position DESC, type_order DESC, entry_index DESC
+		LIMIT ? OFFSET ?`, address, limit, page*limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AddressEntry{}
+
+	for rows.Next() {
+		var entry AddressEntry
+
+		if err := rows.Scan(&entry.Type, &entry.TxHash, &entry.Index, &entry.Value, &entry.BlockHash, &entry.Height, &entry.Timestamp); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// findByPrefix runs a query selecting the values greater than or equal to
+// prefix in ascending order, and keeps the ones starting with prefix.
+func (s *SqlStorage) findByPrefix(query string, prefix string, limit int) ([]string, error) {
+	rows, err := s.db.Query(query, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(value, prefix) {
+			break
+		}
+
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+func (s *SqlStorage) FindBlockHashesByPrefix(prefix string, limit int) ([]string, error) {
+	return s.findByPrefix(`SELECT hash FROM blocks WHERE hash >= ? ORDER BY hash LIMIT ?`, prefix, limit)
+}
+
+func (s *SqlStorage) FindTxHashesByPrefix(prefix string, limit int) ([]string, error) {
+	return s.findByPrefix(`SELECT hash FROM txs WHERE hash >= ? ORDER BY hash LIMIT ?`, prefix, limit)
+}
+
+func (s *SqlStorage) FindAddressesByPrefix(prefix string, limit int) ([]string, error) {
+	return s.findByPrefix(`SELECT DISTINCT o.address FROM tx_outputs o
+		JOIN main_chain_txs t ON t.tx_hash = o.tx_hash
+		WHERE o.address >= ? ORDER BY o.address LIMIT ?`, prefix, limit)
+}
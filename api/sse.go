@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxReplayedBlocks bounds the number of blocks replayed to a client
+	// resuming the stream, the oldest missed blocks being skipped.
+	maxReplayedBlocks = 1000
+
+	sseKeepAlivePeriod = 30 * time.Second
+)
+
+// replayBlocks returns the summaries of the blocks of the main chain from the
+// given height up to the best block.
+func (a *Api) replayBlocks(fromHeight uint32) ([]*BlockSummary, error) {
+	bestBlock, err := a.findBestBlock()
+	if err == ErrBestBlockHashNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if fromHeight > bestBlock.Height {
+		return nil, nil
+	}
+
+	if bestBlock.Height-fromHeight >= maxReplayedBlocks {
+		fromHeight = bestBlock.Height - maxReplayedBlocks + 1
+	}
+
+	var summaries []*BlockSummary
+
+	if err := a.storage.IterateBlocks(fromHeight, bestBlock.Height, false, func(block *Block) error {
+		a.identifyMiner(block)
+		summaries = append(summaries, newBlockSummary(block))
+
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return summaries, nil
+}
+
+func blockSseEvent(summary *BlockSummary) sse.Event {
+	return sse.Event{
+		Id:    strconv.FormatUint(uint64(summary.Height), 10),
+		Event: "block",
+		Data:  summary,
+	}
+}
+
+// handleEvents streams the new best blocks as server-sent events, identified
+// by their height. A client sending a Last-Event-ID first receives the blocks
+// it missed since that height.
+func (a *Api) handleEvents(c *gin.Context) {
+	events := a.synchronizer.Subscribe(func(event *Event) bool {
+		return event.Type == EventBlockConnected
+	})
+	defer a.synchronizer.Unsubscribe(events)
+
+	var replayed []*BlockSummary
+
+	if lastEventId := c.GetHeader("Last-Event-ID"); lastEventId != "" {
+		height, err := strconv.ParseUint(lastEventId, 10, 32)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, "invalid_last_event_id", "Last-Event-ID must be a block height")
+			return
+		}
+
+		replayed, err = a.replayBlocks(uint32(height) + 1)
+		if err != nil {
+			abortWithInternalError(c, err)
+			return
+		}
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// The stream is subscribed to before replaying, the blocks connected in
+	// between are received twice and only sent once.
+	replayedHashes := make(map[string]struct{})
+
+	for _, summary := range replayed {
+		c.Render(-1, blockSseEvent(summary))
+		replayedHashes[summary.Hash] = struct{}{}
+	}
+
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlivePeriod)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+
+			summary := a.identifyEventMiner(event).Data.(*BlockSummary)
+
+			if _, ok := replayedHashes[summary.Hash]; ok {
+				delete(replayedHashes, summary.Hash)
+				return true
+			}
+
+			c.Render(-1, blockSseEvent(summary))
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ":\n\n"); err != nil {
+				return false
+			}
+		case <-c.Request.Context().Done():
+			return false
+		}
+
+		return true
+	})
+}
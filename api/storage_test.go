@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The addresses of the test chains, paid to with pay-to-pubkey-hash scripts.
+var (
+	testAddressA = strings.Repeat("0a", 20)
+	testAddressB = strings.Repeat("0b", 20)
+	testAddressC = strings.Repeat("0c", 20)
+)
+
+func testScript(address string) string {
+	return "64a014" + address + "788caa"
+}
+
+func testHash(id int) string {
+	return fmt.Sprintf("%064x", id)
+}
+
+func testTx(id int, inputs []*Outpoint, outputs map[string]uint64, addresses ...string) *Tx {
+	tx := &Tx{
+		Hash:     testHash(id),
+		Coinbase: len(inputs) == 0,
+	}
+
+	for _, outpoint := range inputs {
+		tx.Inputs = append(tx.Inputs, &TxInput{
+			PreviousOutput: outpoint,
+			Script:         "00",
+		})
+	}
+
+	for _, address := range addresses {
+		tx.Outputs = append(tx.Outputs, &TxOutput{
+			Value:  outputs[address],
+			Script: testScript(address),
+		})
+	}
+
+	return tx
+}
+
+type testBlock struct {
+	block *Block
+	txs   []*Tx
+}
+
+func newTestBlock(id int, parent *testBlock, timestamp uint64, txs ...*Tx) *testBlock {
+	block := &Block{
+		Hash:      testHash(id),
+		Timestamp: timestamp,
+		Target:    halfTarget,
+	}
+
+	if parent != nil {
+		block.PrevBlock = parent.block.Hash
+		block.Height = parent.block.Height + 1
+	}
+
+	return &testBlock{block, txs}
+}
+
+// The test chain forks after the genesis block: the branch a spends the
+// genesis coinbase to the addresses b and c, the longer branch b spends it to
+// the address c.
+var (
+	testCoinbase = testTx(100, nil, map[string]uint64{testAddressA: 50}, testAddressA)
+	testGenesis  = newTestBlock(1, nil, 1000, testCoinbase)
+
+	testBlockA1 = newTestBlock(11, testGenesis, 1100,
+		testTx(110, nil, map[string]uint64{testAddressB: 50}, testAddressB),
+		testTx(111, []*Outpoint{{testCoinbase.Hash, 0}}, map[string]uint64{testAddressB: 30, testAddressC: 20}, testAddressB, testAddressC))
+	testBlockA2 = newTestBlock(12, testBlockA1, 1250,
+		testTx(120, nil, map[string]uint64{testAddressA: 50}, testAddressA))
+
+	testBlockB1 = newTestBlock(21, testGenesis, 1060,
+		testTx(210, nil, map[string]uint64{testAddressC: 50}, testAddressC),
+		testTx(211, []*Outpoint{{testCoinbase.Hash, 0}}, map[string]uint64{testAddressC: 50}, testAddressC))
+	testBlockB2 = newTestBlock(22, testBlockB1, 1200,
+		testTx(220, nil, map[string]uint64{testAddressB: 50}, testAddressB))
+	testBlockB3 = newTestBlock(23, testBlockB2, 1500,
+		testTx(230, nil, map[string]uint64{testAddressB: 50}, testAddressB))
+)
+
+// testStores opens an empty store of each backend.
+func testStores(t *testing.T) (map[string]Store, func()) {
+	dir, err := ioutil.TempDir("", "storage_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]Store{
+		"bolt":   NewBoltStorage(filepath.Join(dir, "data.db")),
+		"sqlite": NewSqlStorage(filepath.Join(dir, "data.sqlite")),
+	}
+
+	for name, store := range stores {
+		if err := store.Open(); err != nil {
+			os.RemoveAll(dir)
+			t.Fatalf("%s: Open() = %v", name, err)
+		}
+	}
+
+	return stores, func() {
+		for _, store := range stores {
+			store.Close()
+		}
+
+		os.RemoveAll(dir)
+	}
+}
+
+func connectTestBlocks(store Store, blocks ...*testBlock) error {
+	for _, b := range blocks {
+		// The stats are computed by the store, on a copy so that every
+		// backend starts from the same block.
+		block := *b.block
+
+		if err := store.ConnectBlocks([]*Block{&block}, [][]*Tx{b.txs}, &Checkpoint{
+			Height: block.Height,
+			Hash:   block.Hash,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func disconnectTestBlocks(store Store, blocks ...*testBlock) error {
+	for _, b := range blocks {
+		block, err := store.FindBlockByHash(b.block.Hash)
+		if err != nil {
+			return err
+		}
+
+		if err := store.DisconnectBlock(block); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type testAddress struct {
+	totalReceived uint64
+	totalSent     uint64
+	txCount       int
+	entries       int
+}
+
+// testStoreState returns what is compared between the backends.
+func testStoreState(store Store) (string, error) {
+	state := make(map[string]interface{})
+
+	bestBlockHash, err := store.FindBestBlockHash()
+	if err != nil && err != ErrBestBlockHashNotFound {
+		return "", err
+	}
+	state["bestBlockHash"] = bestBlockHash
+
+	var blocks []*Block
+	for height := uint32(0); ; height++ {
+		block, err := store.FindBlockByHeight(height)
+		if err == ErrBlockNotFound {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		blocks = append(blocks, block)
+	}
+	state["blocks"] = blocks
+
+	stats, err := store.FindChainStats()
+	if err != nil {
+		return "", err
+	}
+	state["chainStats"] = stats
+
+	for _, address := range []string{testAddressA, testAddressB, testAddressC} {
+		addr, err := store.FindAddress(address)
+		if err != nil && err != ErrAddressNotFound {
+			return "", err
+		}
+
+		entries, err := store.FindAddressEntries(address, 0, 100)
+		if err != nil {
+			return "", err
+		}
+
+		state[address] = []interface{}{addr, entries}
+	}
+
+	stateBytes, err := json.Marshal(state)
+
+	return string(stateBytes), err
+}
+
+func TestStorageReorganization(t *testing.T) {
+	tests := []struct {
+		name       string
+		connect    []*testBlock
+		disconnect []*testBlock
+		reconnect  []*testBlock
+		best       *testBlock
+		chain      []*testBlock
+		spentBy    *SpentBy
+		addresses  map[string]testAddress
+		stats      ChainStats
+	}{
+		{
+			name:    "connect a chain",
+			connect: []*testBlock{testGenesis, testBlockA1, testBlockA2},
+			best:    testBlockA2,
+			chain:   []*testBlock{testGenesis, testBlockA1, testBlockA2},
+			spentBy: &SpentBy{TxHash: testHash(111), InputIndex: 0},
+			addresses: map[string]testAddress{
+				testAddressA: {100, 50, 3, 3},
+				testAddressB: {80, 0, 2, 2},
+				testAddressC: {20, 0, 1, 1},
+			},
+			stats: ChainStats{TotalTxs: 4, TotalOutputs: 5, Supply: 150, TotalBlockInterval: 250, BlockIntervals: 2},
+		},
+		{
+			name:       "disconnect to the fork block",
+			connect:    []*testBlock{testGenesis, testBlockA1, testBlockA2},
+			disconnect: []*testBlock{testBlockA2, testBlockA1},
+			best:       testGenesis,
+			chain:      []*testBlock{testGenesis},
+			addresses: map[string]testAddress{
+				testAddressA: {50, 0, 1, 1},
+			},
+			stats: ChainStats{TotalTxs: 1, TotalOutputs: 1, Supply: 50},
+		},
+		{
+			name:       "connect the other branch",
+			connect:    []*testBlock{testGenesis, testBlockA1, testBlockA2},
+			disconnect: []*testBlock{testBlockA2, testBlockA1},
+			reconnect:  []*testBlock{testBlockB1, testBlockB2, testBlockB3},
+			best:       testBlockB3,
+			chain:      []*testBlock{testGenesis, testBlockB1, testBlockB2, testBlockB3},
+			spentBy:    &SpentBy{TxHash: testHash(211), InputIndex: 0},
+			addresses: map[string]testAddress{
+				testAddressA: {50, 50, 2, 2},
+				testAddressB: {100, 0, 2, 2},
+				testAddressC: {100, 0, 2, 2},
+			},
+			stats: ChainStats{TotalTxs: 5, TotalOutputs: 5, Supply: 200, TotalBlockInterval: 500, BlockIntervals: 3},
+		},
+		{
+			name:       "disconnect the genesis block",
+			connect:    []*testBlock{testGenesis},
+			disconnect: []*testBlock{testGenesis},
+		},
+	}
+
+	for _, test := range tests {
+		stores, cleanup := testStores(t)
+
+		states := make(map[string]string)
+
+		for name, store := range stores {
+			if err := connectTestBlocks(store, test.connect...); err != nil {
+				t.Fatalf("%s, %s: ConnectBlocks() = %v", test.name, name, err)
+			}
+
+			if err := disconnectTestBlocks(store, test.disconnect...); err != nil {
+				t.Fatalf("%s, %s: DisconnectBlock() = %v", test.name, name, err)
+			}
+
+			if err := connectTestBlocks(store, test.reconnect...); err != nil {
+				t.Fatalf("%s, %s: ConnectBlocks() = %v", test.name, name, err)
+			}
+
+			bestBlockHash, err := store.FindBestBlockHash()
+			if test.best == nil {
+				if err != ErrBestBlockHashNotFound {
+					t.Errorf("%s, %s: FindBestBlockHash() = %q, %v, want %v", test.name, name, bestBlockHash, err, ErrBestBlockHashNotFound)
+				}
+			} else if err != nil || bestBlockHash != test.best.block.Hash {
+				t.Errorf("%s, %s: FindBestBlockHash() = %q, %v, want %q", test.name, name, bestBlockHash, err, test.best.block.Hash)
+			}
+
+			for height, b := range test.chain {
+				block, err := store.FindBlockByHeight(uint32(height))
+				if err != nil || block.Hash != b.block.Hash {
+					t.Errorf("%s, %s: FindBlockByHeight(%d) = %v, want %s", test.name, name, height, err, b.block.Hash)
+				}
+			}
+
+			if _, err := store.FindBlockByHeight(uint32(len(test.chain))); err != ErrBlockNotFound {
+				t.Errorf("%s, %s: FindBlockByHeight(%d) = %v, want %v", test.name, name, len(test.chain), err, ErrBlockNotFound)
+			}
+
+			if len(test.chain) > 0 {
+				spentBy, err := store.FindSpentBy(&Outpoint{testCoinbase.Hash, 0})
+				if err != nil || !reflect.DeepEqual(spentBy, test.spentBy) {
+					t.Errorf("%s, %s: FindSpentBy() = %+v, %v, want %+v", test.name, name, spentBy, err, test.spentBy)
+				}
+			}
+
+			for _, address := range []string{testAddressA, testAddressB, testAddressC} {
+				want := test.addresses[address]
+
+				var got testAddress
+
+				addr, err := store.FindAddress(address)
+				if err != nil && err != ErrAddressNotFound {
+					t.Fatalf("%s, %s: FindAddress(%s) = %v", test.name, name, address, err)
+				}
+				if addr != nil {
+					got = testAddress{addr.TotalReceived, addr.TotalSent, addr.TxCount, 0}
+				}
+
+				entries, err := store.FindAddressEntries(address, 0, 100)
+				if err != nil {
+					t.Fatalf("%s, %s: FindAddressEntries(%s) = %v", test.name, name, address, err)
+				}
+				got.entries = len(entries)
+
+				if got != want {
+					t.Errorf("%s, %s: address %s = %+v, want %+v", test.name, name, address, got, want)
+				}
+			}
+
+			stats, err := store.FindChainStats()
+			if err != nil {
+				t.Fatalf("%s, %s: FindChainStats() = %v", test.name, name, err)
+			}
+
+			want := test.stats
+			if test.best != nil {
+				want.Difficulty = TargetToDifficulty(halfTarget)
+			}
+
+			if *stats != want {
+				t.Errorf("%s, %s: FindChainStats() = %+v, want %+v", test.name, name, *stats, want)
+			}
+
+			states[name], err = testStoreState(store)
+			if err != nil {
+				t.Fatalf("%s, %s: %v", test.name, name, err)
+			}
+		}
+
+		if states["bolt"] != states["sqlite"] {
+			t.Errorf("%s: the backends disagree\nbolt:   %s\nsqlite: %s", test.name, states["bolt"], states["sqlite"])
+		}
+
+		cleanup()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+)
+
+var (
+	ErrBestBlockHashNotFound = errors.New("best block hash not found")
+	ErrBlockNotFound         = errors.New("block not found")
+	ErrTxNotFound            = errors.New("tx not found")
+	ErrAddressNotFound       = errors.New("address not found")
+	ErrCheckpointNotFound    = errors.New("checkpoint not found")
+)
+
+// Store is the storage of the blocks and txs synchronized from the node, and
+// of the indexes built upon them.
+type Store interface {
+	Open() error
+	Close() error
+
+	FindBestBlockHash() (string, error)
+
+	// ConnectBlocks stores the blocks, ordered from the oldest to the
+	// newest, and their txs, indexes them, makes the last block the best
+	// block and records the checkpoint, all at once. The stats of the
+	// blocks and txs are computed and stored along with them.
+	ConnectBlocks(blocks []*Block, txs [][]*Tx, checkpoint *Checkpoint) error
+	// DisconnectBlock removes the block from the main chain and records it
+	// as orphaned. The block and its txs are kept so that they can still be
+	// viewed. The best block becomes the parent of the block, if any.
+	DisconnectBlock(block *Block) error
+	FindCheckpoint() (*Checkpoint, error)
+	// FindChainStats returns the aggregates of the main chain, zeroed if no
+	// block is connected.
+	FindChainStats() (*ChainStats, error)
+	// FindActivityBuckets returns the buckets of the interval starting
+	// between from and to included, in ascending order. The buckets without
+	// blocks are omitted.
+	FindActivityBuckets(interval string, from uint64, to uint64) ([]*ActivityBucket, error)
+
+	// StoreHeaders persists the headers of blocks discovered but not
+	// connected yet along with their txs, so that neither an interrupted
+	// synchronization nor the connection of the blocks need to download them
+	// again.
+	StoreHeaders(headers []*Block, txs [][]*Tx) error
+	// PruneHeaders deletes the stored headers but the given ones, those of
+	// the branch being synchronized to.
+	PruneHeaders(keep []*Block) error
+	FindHeader(hash string) (*Block, error)
+	// FindHeaderTxs returns the txs stored along with a header. It returns
+	// ErrBlockNotFound if the header is not stored, or was stored without
+	// its txs.
+	FindHeaderTxs(hash string) ([]*Tx, error)
+
+	HasBlock(hash string) (bool, error)
+	IsInMainChain(hash string) (bool, error)
+	IsOrphanBlock(hash string) (bool, error)
+	FindBlockByHash(hash string) (*Block, error)
+	FindBlockByHeight(height uint32) (*Block, error)
+	// IterateBlocks calls fn on the main chain blocks from fromHeight to
+	// toHeight included, in ascending order or in descending order if
+	// reverse is set. fn must not use the store.
+	IterateBlocks(fromHeight uint32, toHeight uint32, reverse bool, fn func(*Block) error) error
+	// FindBlocksByHeights returns the main chain blocks of the given heights,
+	// read at once so that they belong to the same chain. The heights
+	// without a block are omitted.
+	FindBlocksByHeights(heights []uint32) (map[uint32]*Block, error)
+	// FindBlockHashesByPrefix returns up to limit block hashes starting with
+	// prefix, in ascending order. So do FindTxHashesByPrefix and
+	// FindAddressesByPrefix for txs and addresses.
+	FindBlockHashesByPrefix(prefix string, limit int) ([]string, error)
+
+	FindTxs(blockHash string) ([]*Tx, error)
+	FindTxByHash(hash string) (*Tx, error)
+	FindTxLocation(hash string) (*TxLocation, error)
+	FindTxHashesByPrefix(prefix string, limit int) ([]string, error)
+	// FindSpentBy returns the input spending the given outpoint, or nil if
+	// the outpoint is unspent.
+	FindSpentBy(outpoint *Outpoint) (*SpentBy, error)
+
+	FindAddress(address string) (*Address, error)
+	FindAddressesByPrefix(prefix string, limit int) ([]string, error)
+	// FindAddressEntries returns a page of the history of an address, most
+	// recent entries first.
+	FindAddressEntries(address string, page int, limit int) ([]*AddressEntry, error)
+}
@@ -2,84 +2,323 @@ package main
 
 import (
 	"context"
+	"errors"
 	"github.com/EnsicoinDevs/eccd/utils"
 	pb "github.com/EnsicoinDevs/ensicoin-explorer/api/rpc"
 	log "github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"sync"
+	"time"
 )
 
+const (
+	dialTimeout = 10 * time.Second
+	minBackoff  = time.Second
+	maxBackoff  = time.Minute
+)
+
+var (
+	ErrNodeUnavailable = errors.New("node unavailable")
+
+	errStopIteration = errors.New("stop iteration")
+)
+
+const (
+	SyncStateDisconnected = "disconnected"
+	SyncStateSyncing      = "syncing"
+	SyncStateConnected    = "connected"
+)
+
+type SyncStatus struct {
+	State       string     `json:"state"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	LastSyncAt  *time.Time `json:"last_sync_at,omitempty"`
+}
+
 type Synchronizer struct {
 	rpcServerAddress string
-	storage          *Storage
-	conn             *grpc.ClientConn
-	client           pb.NodeClient
+	storage          Store
+
+	// clientMutex guards the client against the API, which uses it to
+	// publish txs while the client is replaced on each new session.
+	clientMutex sync.RWMutex
+	conn        *grpc.ClientConn
+	client      pb.NodeClient
+
+	statusMutex sync.RWMutex
+	status      SyncStatus
 
-	quit chan struct{}
+	events  *EventBus
+	mempool *Mempool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
-func NewSynchronizer(storage *Storage, rpcServerAddress string) *Synchronizer {
+func NewSynchronizer(storage Store, rpcServerAddress string) *Synchronizer {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Synchronizer{
 		rpcServerAddress: rpcServerAddress,
 		storage:          storage,
 
-		quit: make(chan struct{}),
+		status: SyncStatus{
+			State: SyncStateDisconnected,
+		},
+
+		events:  NewEventBus(),
+		mempool: NewMempool(),
+
+		ctx:    ctx,
+		cancel: cancel,
 	}
 }
 
-func (s *Synchronizer) Start() (err error) {
-	s.conn, err = grpc.Dial(s.rpcServerAddress, grpc.WithInsecure())
-	if err != nil {
-		return err
-	}
+func (s *Synchronizer) Start() error {
+	s.wg.Add(1)
+	go s.run()
 
-	s.client = pb.NewNodeClient(s.conn)
+	return nil
+}
 
-	if err = s.startInitialSynchronization(); err != nil {
-		return err
+func (s *Synchronizer) Stop() error {
+	s.cancel()
+	s.wg.Wait()
+	s.events.Close()
+
+	return nil
+}
+
+func (s *Synchronizer) Status() SyncStatus {
+	s.statusMutex.RLock()
+	defer s.statusMutex.RUnlock()
+
+	return s.status
+}
+
+// Subscribe returns a channel receiving the events of the chain filter
+// accepts, every event if filter is nil. The channel is closed if the
+// subscriber lags too far behind.
+func (s *Synchronizer) Subscribe(filter func(*Event) bool) chan *Event {
+	return s.events.Subscribe(filter)
+}
+
+func (s *Synchronizer) Unsubscribe(events chan *Event) {
+	s.events.Unsubscribe(events)
+}
+
+func (s *Synchronizer) Mempool() *Mempool {
+	return s.mempool
+}
+
+func (s *Synchronizer) setState(state string) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
+
+	s.status.State = state
+
+	if state == SyncStateConnected {
+		now := time.Now()
+		s.status.LastSyncAt = &now
 	}
+}
 
-	go s.startHandler()
+func (s *Synchronizer) setError(err error) {
+	s.statusMutex.Lock()
+	defer s.statusMutex.Unlock()
 
-	return nil
+	now := time.Now()
+
+	s.status.State = SyncStateDisconnected
+	s.status.LastError = err.Error()
+	s.status.LastErrorAt = &now
 }
 
-func (s *Synchronizer) Stop() error {
-	close(s.quit)
+// run keeps the explorer synchronized with the node, dialing it again with an
+// exponential backoff each time the connection is lost.
+func (s *Synchronizer) run() {
+	defer s.wg.Done()
 
-	return s.conn.Close()
+	backoff := minBackoff
+
+	for {
+		synchronized, err := s.runSession()
+
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		if synchronized {
+			backoff = minBackoff
+		}
+
+		s.setError(err)
+
+		log.WithError(err).WithField("retryIn", backoff).Warn("synchronization interrupted")
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
 }
 
-func (s *Synchronizer) startInitialSynchronization() error {
-	bestBlockHash, _, err := s.GetStats()
+// runSession dials the node, catches up with its best block and then follows
+// its best blocks until an error occurs. It reports whether the catch-up
+// succeeded.
+func (s *Synchronizer) runSession() (bool, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, dialTimeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, s.rpcServerAddress, grpc.WithInsecure(), grpc.WithBlock())
 	if err != nil {
-		return err
+		return false, err
 	}
+	defer conn.Close()
 
-	if err = s.synchronizeTo(bestBlockHash); err != nil {
-		return err
+	s.clientMutex.Lock()
+	s.conn = conn
+	s.client = pb.NewNodeClient(conn)
+	s.clientMutex.Unlock()
+
+	sessionCtx, cancelSession := context.WithCancel(s.ctx)
+	defer cancelSession()
+
+	templates, err := s.client.GetBlockTemplate(sessionCtx, &pb.GetBlockTemplateRequest{})
+	if err != nil {
+		return false, err
 	}
 
-	return nil
+	s.wg.Add(1)
+	go s.followBlockTemplates(sessionCtx, s.client, templates)
+
+	return s.synchronize()
+}
+
+// followBlockTemplates keeps the mempool up to date with the txs of the block
+// templates of the node, until the session ends. The stream is opened again
+// with an exponential backoff each time it is interrupted, the mempool being
+// emptied meanwhile.
+func (s *Synchronizer) followBlockTemplates(ctx context.Context, client pb.NodeClient, templates pb.Node_GetBlockTemplateClient) {
+	defer s.wg.Done()
+
+	backoff := minBackoff
+
+	for {
+		received, err := s.receiveBlockTemplates(templates)
+
+		s.mempool.Clear()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if received {
+			backoff = minBackoff
+		}
+
+		log.WithError(err).WithField("retryIn", backoff).Warn("block templates stream interrupted")
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+
+			templates, err = client.GetBlockTemplate(ctx, &pb.GetBlockTemplateRequest{})
+			if err == nil {
+				break
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			log.WithError(err).WithField("retryIn", backoff).Warn("block templates stream not opened")
+		}
+	}
 }
 
-func (s *Synchronizer) startHandler() {
-	stream, err := s.client.GetBestBlocks(context.Background(), &pb.GetBestBlocksRequest{})
+// receiveBlockTemplates updates the mempool with the block templates received
+// until the stream ends. It reports whether any template was received.
+func (s *Synchronizer) receiveBlockTemplates(templates pb.Node_GetBlockTemplateClient) (bool, error) {
+	received := false
+
+	for {
+		reply, err := templates.Recv()
+		if err != nil {
+			return received, err
+		}
+
+		received = true
+
+		s.mempool.Update(RpcBlockTemplateToBlockTemplate(reply.GetBlockTemplate()), RpcTxsToTxs(reply.GetTxs()))
+	}
+}
+
+func (s *Synchronizer) synchronize() (bool, error) {
+	s.setState(SyncStateSyncing)
+
+	// The best blocks stream is opened before catching up so that no best
+	// block is missed in between.
+	stream, err := s.client.GetBestBlocks(s.ctx, &pb.GetBestBlocksRequest{})
 	if err != nil {
-		log.WithError(err).Fatal("fatal error getting the best blocks")
+		return false, err
 	}
 
+	if err = s.startInitialSynchronization(); err != nil {
+		return false, err
+	}
+
+	s.setState(SyncStateConnected)
+
 	for {
 		bestBlockHash, err := stream.Recv()
 		if err != nil {
-			log.Fatal("fatal error receiving a block")
+			return true, err
 		}
 
+		s.setState(SyncStateSyncing)
+
 		if err = s.synchronizeTo(utils.NewHash(bestBlockHash.GetHash()).String()); err != nil {
-			log.WithError(err).Fatal("fatal error synchronizing")
+			return true, err
 		}
+
+		s.setState(SyncStateConnected)
 	}
 }
 
+func (s *Synchronizer) startInitialSynchronization() error {
+	if err := s.resumeFromCheckpoint(); err != nil {
+		return err
+	}
+
+	bestBlockHash, _, err := s.GetStats()
+	if err != nil {
+		return err
+	}
+
+	if err = s.synchronizeTo(bestBlockHash); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s *Synchronizer) synchronizeTo(bestBlockHash string) error {
 	log.WithField("bestBlockHash", bestBlockHash).Info("synchronizing up to")
 
@@ -88,42 +327,206 @@ func (s *Synchronizer) synchronizeTo(bestBlockHash string) error {
 		return err
 	}
 
-	currentHash := bestBlockHash
+	headers, forkBlockHash, err := s.discoverHeaders(bestBlockHash, genesisBlockHash)
+	if err != nil {
+		return err
+	}
+
+	if err = s.disconnectTo(forkBlockHash); err != nil {
+		return err
+	}
 
-	for currentHash != genesisBlockHash {
-		exist, err := s.storage.HasBlock(currentHash)
-		if err != nil {
+	if err = s.connectBlocks(headers); err != nil {
+		return err
+	}
+
+	bestBlock, err := s.storage.FindBlockByHash(bestBlockHash)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"bestBlockHash": bestBlockHash,
+		"height":        bestBlock.Height,
+	}).Info("synchronized")
+
+	return nil
+}
+
+// resumeFromCheckpoint continues an interrupted synchronization from the last
+// checkpoint up to the block it was synchronizing to, whose headers were
+// stored as they were discovered. Databases synchronized before checkpoints
+// existed may have gaps, the blocks above the highest contiguous height are
+// then disconnected so that the synchronization goes forward again from there.
+func (s *Synchronizer) resumeFromCheckpoint() error {
+	checkpoint, err := s.storage.FindCheckpoint()
+	if err == nil {
+		if checkpoint.Hash == checkpoint.TargetHash {
+			return nil
+		}
+
+		if _, err := s.storage.FindHeader(checkpoint.TargetHash); err == ErrBlockNotFound {
+			log.WithField("targetHash", checkpoint.TargetHash).Warn("the checkpoint target header is not stored, not resuming")
+			return nil
+		} else if err != nil {
 			return err
 		}
 
-		if exist {
-			break
+		log.WithFields(log.Fields{
+			"height":     checkpoint.Height,
+			"hash":       checkpoint.Hash,
+			"targetHash": checkpoint.TargetHash,
+		}).Info("resuming synchronization from checkpoint")
+
+		return s.synchronizeTo(checkpoint.TargetHash)
+	}
+	if err != ErrCheckpointNotFound {
+		return err
+	}
+
+	bestBlockHash, err := s.storage.FindBestBlockHash()
+	if err == ErrBestBlockHashNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	bestBlock, err := s.storage.FindBlockByHash(bestBlockHash)
+	if err != nil && err != ErrBlockNotFound {
+		return err
+	}
+
+	var contiguousBlock *Block
+
+	if bestBlock != nil {
+		if err := s.storage.IterateBlocks(0, bestBlock.Height, false, func(block *Block) error {
+			if contiguousBlock == nil && block.Height != 0 {
+				return errStopIteration
+			}
+
+			if contiguousBlock != nil && (block.Height != contiguousBlock.Height+1 || block.PrevBlock != contiguousBlock.Hash) {
+				return errStopIteration
+			}
+
+			contiguousBlock = block
+
+			return nil
+		}); err != nil && err != errStopIteration {
+			return err
 		}
 
-		block, txs, err := s.FindBlockByHash(currentHash)
-		if err != nil {
+		if contiguousBlock != nil && contiguousBlock.Hash == bestBlockHash {
+			return nil
+		}
+	}
+
+	fromHeight := uint32(0)
+	if contiguousBlock != nil {
+		fromHeight = contiguousBlock.Height + 1
+	}
+
+	log.WithField("height", fromHeight).Warn("the stored chain has gaps, synchronizing again from the highest contiguous height")
+
+	return s.disconnectFrom(fromHeight)
+}
+
+// disconnectFrom disconnects the blocks of the main chain from the given
+// height, up to the best block.
+func (s *Synchronizer) disconnectFrom(fromHeight uint32) error {
+	var blocks []*Block
+
+	if err := s.storage.IterateBlocks(fromHeight, ^uint32(0), true, func(block *Block) error {
+		blocks = append(blocks, block)
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, block := range blocks {
+		log.WithFields(log.Fields{
+			"hash":   block.Hash,
+			"height": block.Height,
+		}).Info("disconnecting block")
+
+		if err := s.disconnectBlock(block); err != nil {
 			return err
 		}
+	}
+
+	return nil
+}
+
+// disconnectTo disconnects the blocks of the main chain down to the fork block,
+// which stays connected. If the fork block is empty, the whole main chain is
+// disconnected.
+func (s *Synchronizer) disconnectTo(forkBlockHash string) error {
+	currentHash, err := s.storage.FindBestBlockHash()
+	if err == ErrBestBlockHashNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
 
-		if err = s.storage.StoreBlock(block); err != nil {
+	for currentHash != forkBlockHash {
+		block, err := s.storage.FindBlockByHash(currentHash)
+		if err == ErrBlockNotFound {
+			break
+		}
+		if err != nil {
 			return err
 		}
 
-		if err = s.storage.StoreTxs(block.Hash, txs); err != nil {
+		log.WithFields(log.Fields{
+			"hash":   block.Hash,
+			"height": block.Height,
+		}).Info("disconnecting block")
+
+		if err = s.disconnectBlock(block); err != nil {
 			return err
 		}
 
 		currentHash = block.PrevBlock
 	}
 
-	bestBlock, _ := s.storage.FindBlockByHash(bestBlockHash)
+	return nil
+}
 
-	log.WithFields(log.Fields{
-		"bestBlockHash": bestBlockHash,
-		"height":        bestBlock.Height,
-	}).Info("synchronized")
+func (s *Synchronizer) disconnectBlock(block *Block) error {
+	if err := s.storage.DisconnectBlock(block); err != nil {
+		return err
+	}
+
+	s.events.publishBlockDisconnected(block)
 
-	return s.storage.StoreBestBlockHash(bestBlockHash)
+	return nil
+}
+
+// findBlock returns a block and its txs, from the storage if the block is
+// already known, as it is the case of orphaned blocks, or from the node.
+func (s *Synchronizer) findBlock(hash string) (*Block, []*Tx, error) {
+	exist, err := s.storage.HasBlock(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !exist {
+		return s.FindBlockByHash(hash)
+	}
+
+	block, err := s.storage.FindBlockByHash(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txs, err := s.storage.FindTxs(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return block, txs, nil
 }
 
 func (s *Synchronizer) GetStats() (string, string, error) {
@@ -135,12 +538,32 @@ func (s *Synchronizer) GetStats() (string, string, error) {
 	return utils.NewHash(info.GetBestBlockHash()).String(), utils.NewHash(info.GetGenesisBlockHash()).String(), nil
 }
 
+// PublishRawTx forwards a serialized tx to the node.
+func (s *Synchronizer) PublishRawTx(rawTx []byte) error {
+	s.clientMutex.RLock()
+	client := s.client
+	s.clientMutex.RUnlock()
+
+	if client == nil {
+		return ErrNodeUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, dialTimeout)
+	defer cancel()
+
+	_, err := client.PublishRawTx(ctx, &pb.PublishRawTxRequest{
+		RawTx: rawTx,
+	})
+
+	return err
+}
+
 func (s *Synchronizer) FindBlockByHash(hash string) (*Block, []*Tx, error) {
-	log.WithField("hash", hash).Info("downloading block")
+	log.WithField("hash", hash).Debug("downloading block")
 
 	hashBytes, _ := utils.StringToHash(hash)
 
-	rpcBlock, err := s.client.GetBlockByHash(context.Background(), &pb.GetBlockByHashRequest{
+	rpcBlock, err := s.client.GetBlockByHash(s.ctx, &pb.GetBlockByHashRequest{
 		Hash: hashBytes.Bytes(),
 	})
 	if err != nil {
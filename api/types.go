@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"encoding/hex"
+	"github.com/EnsicoinDevs/eccd/network"
 	"github.com/EnsicoinDevs/eccd/utils"
 	pb "github.com/EnsicoinDevs/ensicoin-explorer/api/rpc"
+	"github.com/EnsicoinDevs/ensicoin-explorer/api/script"
+	"math/big"
+	"strings"
+	"time"
 )
 
 type Block struct {
@@ -15,6 +21,21 @@ type Block struct {
 	Timestamp  uint64   `json:"timestamp"`
 	Height     uint32   `json:"height"`
 	Target     string   `json:"target"`
+	// Stats and Payout are only known once the block is connected.
+	Stats  *BlockStats `json:"stats,omitempty"`
+	Payout *Payout     `json:"payout,omitempty"`
+	// Miner is not stored, it is identified from the payout before serving
+	// the block.
+	Miner string `json:"miner,omitempty"`
+}
+
+type BlockTemplate struct {
+	Version   uint32   `json:"version"`
+	Flags     []string `json:"flags"`
+	PrevBlock string   `json:"prev_block"`
+	Timestamp uint64   `json:"timestamp"`
+	Height    uint32   `json:"height"`
+	Target    string   `json:"target"`
 }
 
 type Outpoint struct {
@@ -22,22 +43,388 @@ type Outpoint struct {
 	Index uint32 `json:"index"`
 }
 
+// The Asm and Type fields are not stored, they are filled by DecodeScripts
+// before serving a tx.
 type TxInput struct {
 	PreviousOutput *Outpoint `json:"previous_output"`
 	Script         string    `json:"script"`
+	Asm            string    `json:"asm,omitempty"`
 }
 
 type TxOutput struct {
 	Value  uint64 `json:"value"`
 	Script string `json:"script"`
+	Asm    string `json:"asm,omitempty"`
+	Type   string `json:"type,omitempty"`
+}
+
+func (input *TxInput) DecodeScript() {
+	scriptBytes, _ := hex.DecodeString(input.Script)
+
+	input.Asm = script.Disassemble(scriptBytes)
+}
+
+func (output *TxOutput) DecodeScript() {
+	scriptBytes, _ := hex.DecodeString(output.Script)
+
+	output.Asm = script.Disassemble(scriptBytes)
+	output.Type = script.Classify(scriptBytes)
+}
+
+// DecodeScripts disassembles the scripts of the tx, and classifies its output
+// scripts.
+func DecodeScripts(tx *Tx) {
+	for _, input := range tx.Inputs {
+		input.DecodeScript()
+	}
+
+	for _, output := range tx.Outputs {
+		output.DecodeScript()
+	}
 }
 
 type Tx struct {
-	Hash    string      `json:"hash"`
-	Version uint32      `json:"version"`
-	Flags   []string    `json:"flags"`
-	Inputs  []*TxInput  `json:"inputs"`
-	Outputs []*TxOutput `json:"outputs"`
+	Hash     string      `json:"hash"`
+	Version  uint32      `json:"version"`
+	Flags    []string    `json:"flags"`
+	Coinbase bool        `json:"coinbase"`
+	Inputs   []*TxInput  `json:"inputs"`
+	Outputs  []*TxOutput `json:"outputs"`
+	// Stats are only known once the tx is confirmed.
+	Stats *TxStats `json:"stats,omitempty"`
+}
+
+// copyTx returns a copy of the tx whose inputs and outputs can be modified
+// without modifying those of the tx.
+func copyTx(tx *Tx) *Tx {
+	copied := *tx
+
+	copied.Inputs = make([]*TxInput, len(tx.Inputs))
+	for i, input := range tx.Inputs {
+		copiedInput := *input
+		copied.Inputs[i] = &copiedInput
+	}
+
+	copied.Outputs = make([]*TxOutput, len(tx.Outputs))
+	for i, output := range tx.Outputs {
+		copiedOutput := *output
+		copied.Outputs[i] = &copiedOutput
+	}
+
+	return &copied
+}
+
+// TxStats are the size and the amounts of a tx. A coinbase tx has no input
+// value, and no fee.
+type TxStats struct {
+	Size        int    `json:"size"`
+	InputValue  uint64 `json:"input_value"`
+	OutputValue uint64 `json:"output_value"`
+	Fee         uint64 `json:"fee"`
+	// FeeRate is the fee per byte.
+	FeeRate float64 `json:"fee_rate"`
+}
+
+func newTxStats(size int, inputValue uint64, outputValue uint64) *TxStats {
+	stats := &TxStats{
+		Size:        size,
+		InputValue:  inputValue,
+		OutputValue: outputValue,
+	}
+
+	// The input value is short of the output value when a spent output is
+	// unknown.
+	if inputValue > outputValue {
+		stats.Fee = inputValue - outputValue
+	}
+
+	if size > 0 {
+		stats.FeeRate = float64(stats.Fee) / float64(size)
+	}
+
+	return stats
+}
+
+type BlockStats struct {
+	Size      int    `json:"size"`
+	TxCount   int    `json:"tx_count"`
+	TotalFees uint64 `json:"total_fees"`
+	// Reward is the output value of the coinbase tx: the block subsidy and
+	// the fees.
+	Reward     uint64  `json:"reward"`
+	Difficulty float64 `json:"difficulty"`
+	// ChainWork is the hex encoded expected number of hashes needed to build
+	// the chain up to the block.
+	ChainWork string `json:"chain_work"`
+}
+
+// ComputeStats fills the stats of the block and of its txs. parent is nil for
+// the genesis block. findOutput returns the output spent by an input, or nil
+// if it is unknown; it is not called for the outputs of the txs of the block
+// itself.
+func ComputeStats(block *Block, parent *Block, txs []*Tx, findOutput func(*Outpoint) (*TxOutput, error)) error {
+	blockTxs := make(map[string]*Tx)
+	for _, tx := range txs {
+		blockTxs[tx.Hash] = tx
+	}
+
+	block.Stats = &BlockStats{
+		Size:       BlockSize(block, txs),
+		TxCount:    len(txs),
+		Difficulty: TargetToDifficulty(block.Target),
+		ChainWork:  chainWork(parent, block.Target),
+	}
+
+	for _, tx := range txs {
+		var inputValue, outputValue uint64
+
+		for _, input := range tx.Inputs {
+			var spentOutput *TxOutput
+
+			if previousTx, ok := blockTxs[input.PreviousOutput.Hash]; ok {
+				if int(input.PreviousOutput.Index) < len(previousTx.Outputs) {
+					spentOutput = previousTx.Outputs[input.PreviousOutput.Index]
+				}
+			} else {
+				var err error
+
+				spentOutput, err = findOutput(input.PreviousOutput)
+				if err != nil {
+					return err
+				}
+			}
+
+			if spentOutput != nil {
+				inputValue += spentOutput.Value
+			}
+		}
+
+		for _, output := range tx.Outputs {
+			outputValue += output.Value
+		}
+
+		tx.Stats = newTxStats(TxSize(tx), inputValue, outputValue)
+
+		block.Stats.TotalFees += tx.Stats.Fee
+		if isCoinbase(tx) {
+			block.Stats.Reward += outputValue
+		}
+	}
+
+	return nil
+}
+
+type SpentBy struct {
+	TxHash     string `json:"tx_hash"`
+	InputIndex int    `json:"input_index"`
+}
+
+type ResolvedTxInput struct {
+	*TxInput
+	SpentOutput *TxOutput `json:"spent_output"`
+}
+
+type ResolvedTxOutput struct {
+	*TxOutput
+	SpentBy *SpentBy `json:"spent_by"`
+}
+
+type ResolvedTx struct {
+	*Tx
+	Inputs  []*ResolvedTxInput  `json:"inputs"`
+	Outputs []*ResolvedTxOutput `json:"outputs"`
+}
+
+const (
+	AddressEntryCredit = "credit"
+	AddressEntryDebit  = "debit"
+)
+
+// AddressEntry is a credit (an output paying to the address) or a debit (an
+// input spending such an output) in an address history. Index is the output
+// index for credits and the input index for debits.
+type AddressEntry struct {
+	Type      string `json:"type"`
+	TxHash    string `json:"tx_hash"`
+	Index     int    `json:"index"`
+	Value     uint64 `json:"value"`
+	BlockHash string `json:"block_hash"`
+	Height    uint32 `json:"height"`
+	Timestamp uint64 `json:"timestamp"`
+}
+
+type Address struct {
+	Address       string `json:"address"`
+	Balance       uint64 `json:"balance"`
+	TotalReceived uint64 `json:"total_received"`
+	TotalSent     uint64 `json:"total_sent"`
+	TxCount       int    `json:"tx_count"`
+}
+
+// Checkpoint records the progress of a synchronization: the last block
+// connected, and the best block of the node that was being synchronized to.
+type Checkpoint struct {
+	Height     uint32 `json:"height"`
+	Hash       string `json:"hash"`
+	TargetHash string `json:"target_hash"`
+}
+
+// ChainStats are running aggregates of the main chain, updated as blocks are
+// connected and disconnected.
+type ChainStats struct {
+	TotalTxs     uint64 `json:"total_txs"`
+	TotalOutputs uint64 `json:"total_outputs"`
+	// Supply is the sum of the outputs of the coinbase txs.
+	Supply uint64 `json:"supply"`
+	// TotalBlockInterval is the sum of the time elapsed since their parent
+	// for the BlockIntervals blocks having one, in seconds.
+	TotalBlockInterval int64  `json:"total_block_interval"`
+	BlockIntervals     uint64 `json:"block_intervals"`
+	// Difficulty is the difficulty of the best block.
+	Difficulty float64 `json:"difficulty"`
+}
+
+// ConnectBlock accounts for the block becoming the best block. parent is nil
+// for the genesis block.
+func (stats *ChainStats) ConnectBlock(block *Block, parent *Block) {
+	stats.Difficulty = TargetToDifficulty(block.Target)
+
+	if parent != nil {
+		stats.TotalBlockInterval += int64(block.Timestamp) - int64(parent.Timestamp)
+		stats.BlockIntervals++
+	}
+}
+
+// DisconnectBlock accounts for parent becoming the best block again.
+func (stats *ChainStats) DisconnectBlock(block *Block, parent *Block) {
+	stats.Difficulty = 0
+
+	if parent != nil {
+		stats.Difficulty = TargetToDifficulty(parent.Target)
+		stats.TotalBlockInterval -= int64(block.Timestamp) - int64(parent.Timestamp)
+		stats.BlockIntervals--
+	}
+}
+
+// AverageBlockInterval returns the mean time between two blocks of the main
+// chain, in seconds.
+func (stats *ChainStats) AverageBlockInterval() float64 {
+	if stats.BlockIntervals == 0 {
+		return 0
+	}
+
+	return float64(stats.TotalBlockInterval) / float64(stats.BlockIntervals)
+}
+
+func (stats *ChainStats) ConnectTxs(txs []*Tx) {
+	for _, tx := range txs {
+		stats.TotalTxs++
+		stats.TotalOutputs += uint64(len(tx.Outputs))
+
+		if isCoinbase(tx) {
+			for _, output := range tx.Outputs {
+				stats.Supply += output.Value
+			}
+		}
+	}
+}
+
+func (stats *ChainStats) DisconnectTxs(txs []*Tx) {
+	for _, tx := range txs {
+		stats.TotalTxs--
+		stats.TotalOutputs -= uint64(len(tx.Outputs))
+
+		if isCoinbase(tx) {
+			for _, output := range tx.Outputs {
+				stats.Supply -= output.Value
+			}
+		}
+	}
+}
+
+// isCoinbase reports whether the tx is a coinbase tx, the only txs without
+// inputs.
+func isCoinbase(tx *Tx) bool {
+	return len(tx.Inputs) == 0
+}
+
+// Payout describes the coinbase tx of a block: the output script the reward is
+// paid to, and the text the miner tagged the block with, if any.
+type Payout struct {
+	Script  string `json:"script"`
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Tag     string `json:"tag,omitempty"`
+}
+
+// newPayout decodes the payout script. An empty script stands for a coinbase
+// tx paying nothing but data.
+func newPayout(scriptHex string, tag string) *Payout {
+	if scriptHex == "" {
+		return &Payout{
+			Tag: tag,
+		}
+	}
+
+	scriptBytes, _ := hex.DecodeString(scriptHex)
+
+	return &Payout{
+		Script:  scriptHex,
+		Address: ScriptToAddress(scriptHex),
+		Type:    script.Classify(scriptBytes),
+		Tag:     tag,
+	}
+}
+
+// FindPayout decodes the coinbase tx of a block. The reward is paid to the
+// largest output not carrying data, and the tag is made of the printable data
+// carried by the other outputs. It returns nil if the block has no coinbase
+// tx.
+func FindPayout(txs []*Tx) *Payout {
+	if len(txs) == 0 || !isCoinbase(txs[0]) {
+		return nil
+	}
+
+	var payoutScript string
+	var payoutValue uint64
+	var tags []string
+
+	for _, output := range txs[0].Outputs {
+		scriptBytes, _ := hex.DecodeString(output.Script)
+
+		if script.Classify(scriptBytes) != script.TypeData {
+			if payoutScript == "" || output.Value > payoutValue {
+				payoutScript = output.Script
+				payoutValue = output.Value
+			}
+
+			continue
+		}
+
+		instructions, _ := script.Parse(scriptBytes)
+		for _, instruction := range instructions {
+			if len(instruction.Data) > 0 && isPrintable(instruction.Data) {
+				tags = append(tags, string(instruction.Data))
+			}
+		}
+	}
+
+	return newPayout(payoutScript, strings.Join(tags, " "))
+}
+
+func isPrintable(data []byte) bool {
+	for _, b := range data {
+		if b < 0x20 || b > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+type TxLocation struct {
+	BlockHash string `json:"block_hash"`
+	Index     int    `json:"index"`
 }
 
 func RpcBlockToBlock(rpcBlock *pb.Block) *Block {
@@ -53,6 +440,17 @@ func RpcBlockToBlock(rpcBlock *pb.Block) *Block {
 	}
 }
 
+func RpcBlockTemplateToBlockTemplate(rpcTemplate *pb.BlockTemplate) *BlockTemplate {
+	return &BlockTemplate{
+		Version:   rpcTemplate.GetVersion(),
+		Flags:     rpcTemplate.GetFlags(),
+		PrevBlock: utils.NewHash(rpcTemplate.GetPrevBlock()).String(),
+		Timestamp: rpcTemplate.GetTimestamp(),
+		Height:    rpcTemplate.GetHeight(),
+		Target:    utils.NewHash(rpcTemplate.GetTarget()).String(),
+	}
+}
+
 func RpcTxInputsToTxInputs(inputs []*pb.TxInput) []*TxInput {
 	var txInputs []*TxInput
 
@@ -82,18 +480,100 @@ func RpcTxOutputsToTxOutputs(outputs []*pb.TxOutput) []*TxOutput {
 	return txOutputs
 }
 
-func RpcBlockToTxs(rpcBlock *pb.Block) []*Tx {
+func RpcTxToTx(tx *pb.Tx) *Tx {
+	return &Tx{
+		Hash:     utils.NewHash(tx.GetHash()).String(),
+		Version:  tx.GetVersion(),
+		Flags:    tx.GetFlags(),
+		Coinbase: len(tx.GetInputs()) == 0,
+		Inputs:   RpcTxInputsToTxInputs(tx.GetInputs()),
+		Outputs:  RpcTxOutputsToTxOutputs(tx.GetOutputs()),
+	}
+}
+
+func RpcTxsToTxs(rpcTxs []*pb.Tx) []*Tx {
 	var txs []*Tx
 
-	for _, tx := range rpcBlock.GetTxs() {
-		txs = append(txs, &Tx{
-			Hash:    utils.NewHash(tx.GetHash()).String(),
-			Version: tx.GetVersion(),
-			Flags:   tx.GetFlags(),
-			Inputs:  RpcTxInputsToTxInputs(tx.GetInputs()),
-			Outputs: RpcTxOutputsToTxOutputs(tx.GetOutputs()),
-		})
+	for _, tx := range rpcTxs {
+		txs = append(txs, RpcTxToTx(tx))
 	}
 
 	return txs
 }
+
+func RpcBlockToTxs(rpcBlock *pb.Block) []*Tx {
+	return RpcTxsToTxs(rpcBlock.GetTxs())
+}
+
+func TxToTxMessage(tx *Tx) *network.TxMessage {
+	msg := &network.TxMessage{
+		Version: tx.Version,
+		Flags:   tx.Flags,
+	}
+
+	for _, input := range tx.Inputs {
+		hash, _ := utils.StringToHash(input.PreviousOutput.Hash)
+		script, _ := hex.DecodeString(input.Script)
+
+		msg.Inputs = append(msg.Inputs, &network.TxIn{
+			PreviousOutput: &network.Outpoint{
+				Hash:  *hash,
+				Index: input.PreviousOutput.Index,
+			},
+			Script: script,
+		})
+	}
+
+	for _, output := range tx.Outputs {
+		script, _ := hex.DecodeString(output.Script)
+
+		msg.Outputs = append(msg.Outputs, &network.TxOut{
+			Value:  output.Value,
+			Script: script,
+		})
+	}
+
+	return msg
+}
+
+func BlockToBlockMessage(block *Block, txs []*Tx) *network.BlockMessage {
+	prevBlock, _ := utils.StringToHash(block.PrevBlock)
+	merkleRoot, _ := utils.StringToHash(block.MerkleRoot)
+	target, _ := utils.StringToHash(block.Target)
+
+	msg := &network.BlockMessage{
+		Header: &network.BlockHeader{
+			Version:        block.Version,
+			Flags:          block.Flags,
+			HashPrevBlock:  prevBlock,
+			HashMerkleRoot: merkleRoot,
+			Timestamp:      time.Unix(int64(block.Timestamp), 0),
+			Height:         block.Height,
+			Target:         new(big.Int).SetBytes(target.Bytes()),
+		},
+	}
+
+	for _, tx := range txs {
+		msg.Txs = append(msg.Txs, TxToTxMessage(tx))
+	}
+
+	return msg
+}
+
+// TxSize returns the size in bytes of the tx as serialized on the ensicoin
+// network.
+func TxSize(tx *Tx) int {
+	buf := bytes.NewBuffer(nil)
+	_ = TxToTxMessage(tx).Encode(buf)
+
+	return buf.Len()
+}
+
+// BlockSize returns the size in bytes of the block as serialized on the
+// ensicoin network.
+func BlockSize(block *Block, txs []*Tx) int {
+	buf := bytes.NewBuffer(nil)
+	_ = BlockToBlockMessage(block, txs).Encode(buf)
+
+	return buf.Len()
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// WsRequest is a message sent by a WebSocket client to change the addresses
+// and txs it is subscribed to.
+type WsRequest struct {
+	Action    string   `json:"action"`
+	Addresses []string `json:"addresses"`
+	Txs       []string `json:"txs"`
+}
+
+// wsSubscriptions are the addresses and txs a WebSocket client wants to
+// receive the tx_confirmed events of.
+type wsSubscriptions struct {
+	mutex     sync.RWMutex
+	addresses map[string]struct{}
+	txs       map[string]struct{}
+}
+
+func (s *wsSubscriptions) update(request *WsRequest) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch request.Action {
+	case "subscribe":
+		for _, address := range request.Addresses {
+			s.addresses[address] = struct{}{}
+		}
+		for _, tx := range request.Txs {
+			s.txs[tx] = struct{}{}
+		}
+	case "unsubscribe":
+		for _, address := range request.Addresses {
+			delete(s.addresses, address)
+		}
+		for _, tx := range request.Txs {
+			delete(s.txs, tx)
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// matches reports whether the tx_confirmed event is subscribed to, either by
+// its tx hash or by one of the addresses it credits or debits.
+func (s *wsSubscriptions) matches(event *TxEvent) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if _, ok := s.txs[event.Tx.Hash]; ok {
+		return true
+	}
+
+	for address := range event.addresses {
+		if _, ok := s.addresses[address]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleWebSocket pushes the chain events to the client. Every client receives
+// the block events, while tx_confirmed events are only sent for the addresses
+// and txs the client subscribed to.
+func (a *Api) handleWebSocket(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		log.WithError(err).Debug("error upgrading to websocket")
+		return
+	}
+	defer conn.Close()
+
+	subscriptions := &wsSubscriptions{
+		addresses: make(map[string]struct{}),
+		txs:       make(map[string]struct{}),
+	}
+
+	// The tx_confirmed events are matched against the subscriptions as they
+	// are published, so that the txs of the other clients do not fill the
+	// buffer of this one.
+	events := a.synchronizer.Subscribe(func(event *Event) bool {
+		txEvent, isTxEvent := event.Data.(*TxEvent)
+
+		return !isTxEvent || subscriptions.matches(txEvent)
+	})
+	defer a.synchronizer.Unsubscribe(events)
+
+	replies := make(chan interface{}, 1)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+
+		for {
+			_, requestBytes, err := conn.ReadMessage()
+			if err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+					log.WithError(err).Debug("error reading from websocket")
+				}
+				return
+			}
+
+			var request WsRequest
+			if err := json.Unmarshal(requestBytes, &request); err != nil || !subscriptions.update(&request) {
+				select {
+				case replies <- gin.H{
+					"error": &ApiError{
+						Code:    "invalid_request",
+						Message: "requests must be JSON objects with a subscribe or unsubscribe action",
+					},
+				}:
+				default:
+				}
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		var message interface{}
+
+		select {
+		case event, ok := <-events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "event stream closed"), time.Now().Add(wsWriteWait))
+				return
+			}
+
+			message = a.identifyEventMiner(event)
+		case message = <-replies:
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				return
+			}
+			continue
+		case <-done:
+			return
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(message); err != nil {
+			return
+		}
+	}
+}
@@ -3,7 +3,8 @@ module.exports = {
     proxy: {
       '/api': {
         target: 'http://localhost:4000',
-        changeOrigin: true
+        changeOrigin: true,
+        ws: true
       }
     }
   }